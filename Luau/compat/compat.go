@@ -0,0 +1,304 @@
+// Package compat makes Luau code compatible with Lua, by replacing syntax
+// that Lua doesn't support.
+package compat
+
+import (
+	luau "Luau/binding"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// ErrParse is returned when the source isn't valid Luau
+var ErrParse = errors.New("error parsing code")
+
+// UnsupportedError is Luau syntax that can't be converted to Lua, which would
+// otherwise be passed through as it is
+type UnsupportedError struct {
+	Line, Column int // from 1
+	Syntax       string
+}
+
+func (e *UnsupportedError) Error() string {
+	return e.Syntax + " can't be converted to Lua without darklua"
+}
+
+// syntax that isn't converted, by node type. Types are removed by
+// stripTypes, so any left are somewhere it doesn't know to look.
+var unsupportedNodes = map[string]string{
+	"type":          "a type annotation",
+	"namedtype":     "a type annotation",
+	"tbtype":        "a type annotation",
+	"fntype":        "a type annotation",
+	"bintype":       "a type annotation",
+	"untype":        "a type annotation",
+	"wraptype":      "a type annotation",
+	"singleton":     "a type annotation",
+	"dyntype":       "a type annotation",
+	"typepack":      "a type annotation",
+	"type_stmt":     "a type definition",
+	"cast":          "a type cast",
+	"generic":       "a generic type",
+	"genericdef":    "a generic type",
+	"string_interp": "string interpolation",
+	"continue_stmt": "continue",
+}
+
+// findUnsupported returns the first syntax in a tree that can't be converted
+func findUnsupported(node *sitter.Node) *UnsupportedError {
+	syntax, ok := unsupportedNodes[node.Type()]
+	if !ok || !node.IsNamed() {
+		syntax = ""
+	}
+	// compound assignment, apart from //= which is converted
+	if node.Type() == "var_stmt" && node.ChildCount() > 1 {
+		if op := node.Child(1).Type(); strings.HasSuffix(op, "=") && op != "=" && op != "//=" {
+			syntax = op
+		}
+	}
+	if syntax != "" {
+		start := node.StartPoint()
+		return &UnsupportedError{int(start.Row) + 1, int(start.Column) + 1, syntax}
+	}
+
+	for i := range int(node.ChildCount()) {
+		if err := findUnsupported(node.Child(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripTypes removes type annotations, casts, generics and type definitions,
+// which Lua has no use for, keeping the newlines in anything removed
+func stripTypes(source []byte, root *sitter.Node) []byte {
+	type span struct{ start, end uint32 }
+	var spans []span
+
+	var find func(node *sitter.Node)
+	find = func(node *sitter.Node) {
+		switch node.Type() {
+		case "type_stmt":
+			spans = append(spans, span{node.StartByte(), node.EndByte()})
+			return
+		case "binding", "param", "cast":
+			// everything after the name or expression is the type
+			for i := 1; i < int(node.ChildCount()); i++ {
+				if t := node.Child(i).Type(); t == ":" || t == "::" {
+					spans = append(spans, span{node.Child(i - 1).EndByte(), node.EndByte()})
+					break
+				}
+			}
+		case "fn_stmt", "local_fn_stmt", "anon_fn":
+			params := false
+			for i := range int(node.ChildCount()) {
+				child := node.Child(i)
+				switch child.Type() {
+				case "<":
+					for j := i + 1; j < int(node.ChildCount()); j++ {
+						if node.Child(j).Type() == ">" {
+							spans = append(spans, span{child.StartByte(), node.Child(j).EndByte()})
+							break
+						}
+					}
+				case ")":
+					params = true
+				case ":":
+					// the return type, rather than the colon in a method name
+					if params && i+1 < int(node.ChildCount()) {
+						spans = append(spans, span{child.StartByte(), node.Child(i + 1).EndByte()})
+					}
+				}
+			}
+		}
+		for i := range int(node.ChildCount()) {
+			find(node.Child(i))
+		}
+	}
+	find(root)
+
+	// function types can have their own, inside the ones found already
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var out []byte
+	var last uint32
+	for _, s := range spans {
+		if s.start < last {
+			continue
+		}
+		out = append(out, source[last:s.start]...)
+		out = append(out, bytes.Repeat([]byte("\n"), bytes.Count(source[s.start:s.end], []byte("\n")))...)
+		last = s.end
+	}
+	return append(out, source[last:]...)
+}
+
+func randomString(length int) string {
+	// generate random unicode string
+	var str string
+	for i := 0; i < length; i++ {
+		str += string(rune(rand.Intn(0x7E-0x21) + 0x21))
+	}
+	return str
+}
+
+func compatifyCode(sourceCode []byte, tree *sitter.Tree) (string, error) {
+	node := tree.RootNode()
+	if node.HasError() {
+		return "", ErrParse
+	}
+
+	// watch out for passing by reference
+	newSource := make([]byte, len(sourceCode))
+	copy(newSource, sourceCode)
+
+	type sub struct {
+		node      *sitter.Node
+		toReplace string
+	}
+	var toSub []sub
+
+	var findExprs func(node sitter.Node)
+	findExprs = func(node sitter.Node) {
+		start := node.StartByte()
+		end := node.EndByte()
+		rand := randomString(int(end - start))
+		ntype := node.Type()
+
+		if ntype == "ifexp" || (ntype == "binexp" && node.Child(1).Type() == "//") || (ntype == "var_stmt" && node.Child(1).Type() == "//=") {
+			// replace unsupported statements/expressions with a random string
+			newSource = append(newSource[:start], append([]byte(rand), newSource[end:]...)...)
+			toSub = append(toSub, sub{&node, rand})
+		} else {
+			for i := range int(node.ChildCount()) {
+				findExprs(*(node.Child(i)))
+			}
+		}
+	}
+
+	findExprs(*node)
+	sourceString := string(newSource)
+
+	for _, s := range toSub {
+		var replacement string
+
+		node := s.node
+		ntype := node.Type()
+
+		switch ntype {
+		case "ifexp":
+			secondCond := node.Child(3).Type()
+			hasElseIf := node.Child(4).Type() == "elseif"
+
+			if !hasElseIf && map[string]bool{
+				"number":        true,
+				"string":        true,
+				"string_interp": true,
+				"true":          true, // lelel
+			}[secondCond] {
+				// SPECIAL CASE: the second condition is guaranteed to be truthy
+				// this means it can be simplified to Lua's sorta-ternary operator, a and b or c
+
+				// doesn't simplify nested if expressions. GOOD ENOUGH
+				for i := range int(node.ChildCount()) {
+					child := node.Child(i)
+					switch child.Type() {
+					case "then":
+						replacement += "and "
+					case "else":
+						replacement += "or "
+					case "elseif":
+						return "", errors.New("elseif in special case")
+					case "if":
+						// nothing
+					default:
+						replacement += child.Content(sourceCode) + " "
+					}
+				}
+			} else {
+				replacement += "(function()"
+				for i := range int(node.ChildCount()) {
+					child := node.Child(i)
+					switch child.Type() {
+					case "if", "elseif", "then":
+						replacement += child.Content(sourceCode) + " "
+					case "else":
+						replacement += "end "
+					default:
+						prev := node.Child(i - 1).Type()
+						if prev == "then" || prev == "else" {
+							replacement += "return "
+						}
+						replacement += child.Content(sourceCode) + " "
+					}
+				}
+				replacement += "end)()"
+			}
+		case "binexp":
+			// child 1 is the operator (//)
+			left := node.Child(0).Content(sourceCode)
+			right := node.Child(2).Content(sourceCode)
+			replacement = fmt.Sprintf("math.floor(%s/%s)", left, right)
+		case "var_stmt":
+			// child 1 is the operator (//=)
+			left := node.Child(0).Content(sourceCode)
+			right := node.Child(2).Content(sourceCode)
+
+			// todo: make it so if one of the exprs is a function it don't get evaluated twice
+			// nah jk im not doing that
+			replacement = fmt.Sprintf("%s=math.floor(%s/%s)", left, left, right)
+		default:
+			return "", fmt.Errorf("unhandled node type %s", ntype)
+		}
+		// keep the lines after it where they were
+		replacement += strings.Repeat("\n", strings.Count(node.Content(sourceCode), "\n"))
+
+		sourceString = strings.Replace(sourceString, s.toReplace, replacement, 1)
+	}
+
+	// remove ending newlines, but not starting ones, so lines don't move
+	sourceString = strings.TrimRight(sourceString, "\n")
+	return sourceString, nil
+}
+
+// Compatify converts Luau source code to Lua
+func Compatify(sourceCode []byte) (string, error) {
+	binding := luau.GetLuau()
+	code := string(sourceCode)
+
+	// keep parsing until no changes are made lmao
+	for {
+		parser := sitter.NewParser()
+		parser.SetLanguage(binding)
+
+		newSource := []byte(code)
+		tree, err := parser.ParseCtx(context.Background(), nil, newSource)
+		if err != nil {
+			return "", err
+		}
+		if root := tree.RootNode(); !root.HasError() {
+			if stripped := stripTypes(newSource, root); !bytes.Equal(stripped, newSource) {
+				code = string(stripped)
+				continue
+			}
+			if err := findUnsupported(root); err != nil {
+				return "", err
+			}
+		}
+		compatible, err := compatifyCode(newSource, tree)
+		if err != nil {
+			return "", err
+		}
+		compatible = strings.TrimRight(compatible, "\n")
+
+		if compatible == code {
+			return code, nil
+		}
+		code = compatible
+	}
+}
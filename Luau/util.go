@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"os"
 
 	c "github.com/TwiN/go-color"
@@ -20,12 +19,3 @@ func Assert(err error, txt string) {
 		Error(txt)
 	}
 }
-
-func randomString(length int) string {
-	// generate random unicode string
-	var str string
-	for i := 0; i < length; i++ {
-		str += string(rune(rand.Intn(0x7E-0x21) + 0x21))
-	}
-	return str
-}
@@ -4,6 +4,22 @@ local initiated = false
 local HttpService = game:GetService "HttpService"
 HttpService.HttpEnabled = true
 
+local SERVER = "http://localhost:2013"
+local token = plugin:GetSetting "MercurySyncToken" or ""
+
+-- every request needs the pairing token the server printed when it started
+local function url(path)
+	local sep = path:find "?" and "&" or "?"
+	return SERVER .. path .. sep .. "token=" .. token
+end
+
+local function failure(err)
+	if tostring(err):find "401" then
+		return "Incorrect pairing token! Pair with the token the server printed and try again."
+	end
+	return "Is Mercury Sync Server running?"
+end
+
 local function initiate()
 	if initiated then
 		return
@@ -24,6 +40,9 @@ local buttons = {
 		"Sync!", -- hover text
 		"icon.png" -- The icon file's name. Make sure you change it to your own icon file's name!
 	),
+	toolbar:CreateButton("", "Write selected scripts back to disk", "icon.png"),
+	toolbar:CreateButton("", "Toggle automatic syncing", "icon.png"),
+	toolbar:CreateButton("", "Pair with Mercury Sync Server", "icon.png"),
 }
 
 local Fusion = LoadLibrary "RbxFusion"
@@ -188,24 +207,200 @@ local function notify(text, willUpdate)
 	return
 end
 
-local function makeScript(s) -- because no continue
-	local path = s.path -- { "ServerScriptService", "script" }
-	local content = s.content
-	local filetype = s.type
+local scriptClasses = {
+	server = "Script",
+	client = "LocalScript",
+	module = "ModuleScript",
+}
 
+local function isScript(obj)
+	return obj:IsA "Script" or obj:IsA "ModuleScript"
+end
+
+local function getChild(obj, name)
+	local child = obj:FindFirstChild(name)
+	if not child and obj == game then
+		-- services may not have been created yet
+		pcall(function()
+			child = game:GetService(name)
+		end)
+	end
+	return child
+end
+
+-- finds the parent of the object at a path, creating it if it doesn't exist
+local function getParent(path)
 	local obj = game
 	local ok, err = pcall(function()
 		for i = 1, #path - 1 do
-			obj = obj:FindFirstChild(path[i])
-			if not obj then
-				local folder = Instance.new "Model"
-				folder.Name = path[i]
-				folder.Parent = obj
-				obj = folder
+			local child = getChild(obj, path[i])
+			if not child then
+				child = Instance.new "Model"
+				child.Name = path[i]
+				child.Parent = obj
 			end
+			obj = child
 		end
 	end)
+	return ok and obj, err
+end
+
+local function findPath(path)
+	local obj = game
+	for i = 1, #path do
+		obj = getChild(obj, path[i])
+		if not obj then
+			return
+		end
+	end
+	return obj
+end
+
+local function removeScript(r) -- { path, type, renamedTo }
+	local path = r.path
+	local obj = findPath(path)
+	if not obj or (r.type ~= "instance" and not isScript(obj)) then
+		return
+	end
+
+	if r.renamedTo then
+		-- move the existing script, so anything referring to it still works
+		local parent, err = getParent(r.renamedTo)
+		if not parent then
+			print("Failed to rename:", err)
+			return
+		end
+		obj.Name = r.renamedTo[#r.renamedTo]
+		obj.Parent = parent
+		return
+	end
+
+	-- an init script may still have children that exist on disk
+	local children = obj:GetChildren()
+	if #children > 0 then
+		local folder = Instance.new "Model"
+		folder.Name = obj.Name
+		for _, child in pairs(children) do
+			child.Parent = folder
+		end
+		folder.Parent = obj.Parent
+	end
+	obj:Destroy()
+end
+
+-- typed property values are sent as { TypeName = { ...components } }
+local valueTypes = {
+	Vector3 = Vector3.new,
+	Vector2 = Vector2.new,
+	Color3 = Color3.new,
+	UDim2 = UDim2.new,
+	CFrame = CFrame.new,
+	BrickColor = BrickColor.new,
+	-- only the build needs to know which numbers are whole
+	int = tonumber,
+	float = tonumber,
+}
+
+local function propertyValue(v)
+	if type(v) ~= "table" then
+		return v
+	end
+	for typeName, components in pairs(v) do
+		local constructor = valueTypes[typeName]
+		if not constructor then
+			error("Unknown property type " .. tostring(typeName))
+		end
+		if type(components) == "table" then
+			return constructor(unpack(components))
+		end
+		return constructor(components)
+	end
+end
+
+-- cleared properties go back to what a new instance of the class has
+local function applyProperties(obj, properties, attributes, cleared, clearedAttributes)
+	if cleared and #cleared > 0 then
+		local default = Instance.new(obj.ClassName)
+		for _, k in pairs(cleared) do
+			obj[k] = default[k]
+		end
+		default:Destroy()
+	end
+	if clearedAttributes then
+		for _, k in pairs(clearedAttributes) do
+			obj:SetAttribute(k, nil)
+		end
+	end
+	if properties then
+		for k, v in pairs(properties) do
+			obj[k] = propertyValue(v)
+		end
+	end
+	if attributes then
+		for k, v in pairs(attributes) do
+			obj:SetAttribute(k, propertyValue(v))
+		end
+	end
+end
+
+-- creates or updates an instance tree, returning the instance
+local function applyInstance(desc, parent, name) -- { className, properties, children }
+	local obj = parent:FindFirstChild(name)
+	if obj and obj.ClassName ~= desc.className then
+		error(
+			"Object already exists at path "
+				.. obj:GetFullName()
+				.. " with a different class"
+		)
+	end
+
+	local created = not obj
+	if created then
+		obj = Instance.new(desc.className)
+		obj.Name = name
+	end
+	applyProperties(obj, desc.properties)
+	if desc.children then
+		for _, child in pairs(desc.children) do
+			applyInstance(child, obj, child.name)
+		end
+	end
+	if created then
+		obj.Parent = parent
+	end
+	return obj
+end
+
+local function makeContainer(c) -- { path, className, properties, attributes, cleared, clearedAttributes }
+	local path = c.path
+	local parent, err = getParent(path)
+	if not parent then
+		print("Failed to create container:", err)
+		return
+	end
+
+	local ok, err2 = pcall(function()
+		local container = getChild(parent, path[#path])
+		if not container then
+			container = Instance.new(c.className)
+			container.Name = path[#path]
+			container.Parent = parent
+		end
+		applyProperties(container, c.properties, c.attributes, c.cleared, c.clearedAttributes)
+	end)
 	if not ok then
+		notify("Failed to create " .. c.className .. " " .. table.concat(path, ".") .. "!")
+		print("Failed to create container:", err2)
+	end
+end
+
+local function makeScript(s) -- because no continue
+	local path = s.path -- { "ServerScriptService", "script" }
+	local content = s.content
+	local filetype = s.type
+
+	local obj, err = getParent(path)
+	if not obj then
 		notify(
 			"Failed to sync "
 				.. table.concat(path, ".")
@@ -215,12 +410,35 @@ local function makeScript(s) -- because no continue
 		return
 	end
 
+	if filetype == "instance" then
+		local ok, err2 = pcall(function()
+			-- cleared first, so the model's own properties are set over the defaults
+			local existing = getChild(obj, path[#path])
+			if existing then
+				applyProperties(existing, nil, nil, s.cleared, s.clearedAttributes)
+			end
+			local inst = applyInstance(s.instance, obj, path[#path])
+			applyProperties(inst, s.properties, s.attributes)
+		end)
+		if not ok then
+			notify("Failed to sync " .. table.concat(path, ".") .. "!")
+			print("Failed to sync instance:", err2)
+		end
+		return
+	end
+
 	local name = path[#path]
-	local existingObj = obj:FindFirstChild(name)
+	local existingObj = getChild(obj, name)
+	local replaced
+	if existingObj and isScript(existingObj) and existingObj.ClassName ~= scriptClasses[filetype] then
+		-- the script type changed, so it has to be made again
+		replaced, existingObj = existingObj, nil
+	end
 	if existingObj then
-		if existingObj:IsA "Script" then
+		if isScript(existingObj) then
 			local ok2, err2 = pcall(function()
 				existingObj.Source = content
+				applyProperties(existingObj, s.properties, s.attributes, s.cleared, s.clearedAttributes)
 			end)
 			if not ok2 then
 				notify(
@@ -240,15 +458,10 @@ local function makeScript(s) -- because no continue
 			return
 		end
 	else
-		local createScript
-
-		if filetype == "server" then
-			createScript = Instance.new "Script"
-		elseif filetype == "client" then
-			createScript = Instance.new "LocalScript"
-		else
+		if not scriptClasses[filetype] then
 			return
 		end
+		local createScript = Instance.new(scriptClasses[filetype])
 
 		print("Name", name)
 		print("Content", content, type(content))
@@ -262,6 +475,13 @@ local function makeScript(s) -- because no continue
 		local ok2, err2 = pcall(function()
 			createScript.Name = name
 			createScript.Source = content
+			applyProperties(createScript, s.properties, s.attributes)
+			if replaced then
+				for _, child in pairs(replaced:GetChildren()) do
+					child.Parent = createScript
+				end
+				replaced:Destroy()
+			end
 			createScript.Parent = obj
 		end)
 		if not ok2 then
@@ -276,7 +496,40 @@ local function makeScript(s) -- because no continue
 	end
 end
 
+-- prints each problem the server found, apart from info, returning how many
+-- were errors and warnings
+local function report(diagnostics) -- { severity, file, line, column, message }
+	local errors, warnings = 0, 0
+	if not diagnostics or diagnostics == "null" then
+		return errors, warnings
+	end
+
+	for _, d in pairs(diagnostics) do
+		-- info is about files that aren't synced, like tool configs, which
+		-- would be printed on every sync
+		if d.severity ~= "info" then
+			local pos = d.file
+			if d.line then
+				pos = pos .. ":" .. d.line
+				if d.column then
+					pos = pos .. ":" .. d.column
+				end
+			end
+			print(pos .. ": " .. d.severity .. ": " .. d.message)
+		end
+
+		if d.severity == "error" then
+			errors = errors + 1
+			notify("Failed to compile " .. d.file .. "!")
+		elseif d.severity == "warning" then
+			warnings = warnings + 1
+		end
+	end
+	return errors, warnings
+end
+
 local debounce
+local revision = 0 -- of the last changes applied, so automatic syncing carries on from there
 
 buttons[1].Click:connect(function()
 	if debounce then
@@ -297,7 +550,7 @@ buttons[1].Click:connect(function()
 	Spawn(function()
 		local ok, res = ypcall(function()
 			return HttpService:GetAsync(
-				"http://localhost:2013/sync?" .. tick() * 10000
+				url("/sync?since=" .. revision .. "&" .. tick() * 10000)
 				-- nocache parameter doesn't work
 			)
 		end)
@@ -309,27 +562,211 @@ buttons[1].Click:connect(function()
 		end
 
 		if not ok then
-			n.text:set "Failed to sync! Is Mercury Sync Server running?"
+			n.text:set("Failed to sync! " .. failure(res))
 			finish()
 			return
 		end
 
 		n.text:set "Decoding..."
-		local json = HttpService:JSONDecode(res) -- { files }
-
-		if not json.files or json.files == "null" then
-			n.text:set "No files to sync!"
+		local json = HttpService:JSONDecode(res) -- { files, removed, diagnostics }
+		local errors, warnings = report(json.diagnostics)
+		revision = json.revision
+
+		local hasRemoved = json.removed and json.removed ~= "null"
+		if (not json.files or json.files == "null") and not hasRemoved then
+			if errors > 0 then
+				n.text:set(errors .. " file(s) failed to compile! See the output for details.")
+			else
+				n.text:set "No files to sync!"
+			end
 			finish()
 			return
 		end
 		n.text:set "Applying..."
 
-		for _, v in pairs(json.files) do -- { path, content, type }
-			makeScript(v)
+		if json.containers and json.containers ~= "null" then
+			for _, v in pairs(json.containers) do -- { path, className }
+				makeContainer(v)
+			end
+		end
+		if hasRemoved then
+			for _, v in pairs(json.removed) do -- { path, renamedTo }
+				removeScript(v)
+			end
+		end
+		if json.files and json.files ~= "null" then
+			for _, v in pairs(json.files) do -- { path, content, type, instance }
+				makeScript(v)
+			end
 		end
 
-		n.text:set "Successfully synchronised!"
+		if errors > 0 then
+			n.text:set("Synchronised, but " .. errors .. " file(s) failed to compile! See the output for details.")
+		elseif warnings > 0 then
+			n.text:set("Synchronised with " .. warnings .. " warning(s). See the output for details.")
+		else
+			n.text:set "Successfully synchronised!"
+		end
 
 		finish()
 	end)
 end)
+
+local function scriptType(obj)
+	if obj:IsA "LocalScript" then
+		return "client"
+	elseif obj:IsA "Script" then
+		return "server"
+	elseif obj:IsA "ModuleScript" then
+		return "module"
+	end
+end
+
+local function pathOf(obj)
+	local path = {}
+	while obj and obj ~= game do
+		table.insert(path, 1, obj.Name)
+		obj = obj.Parent
+	end
+	return path
+end
+
+buttons[2].Click:connect(function()
+	local selected = {}
+	for _, obj in pairs(game:GetService("Selection"):Get()) do
+		if scriptType(obj) then
+			table.insert(selected, obj)
+		end
+	end
+	if #selected == 0 then
+		notify "Select the scripts to write back first!"
+		return
+	end
+
+	Spawn(function()
+		local written = 0
+		for _, obj in pairs(selected) do
+			local path = pathOf(obj)
+			local ok, err = ypcall(function()
+				return HttpService:PostAsync(
+					url "/write",
+					HttpService:JSONEncode {
+						path = path,
+						source = obj.Source,
+						type = scriptType(obj),
+					}
+				)
+			end)
+			if ok then
+				written = written + 1
+			else
+				-- 409 means the file changed on disk, or is compiled from another language
+				notify("Failed to write " .. table.concat(path, ".") .. "!")
+				print("Failed to write back:", err)
+			end
+		end
+		notify("Wrote " .. written .. " script(s) to disk.")
+	end)
+end)
+
+-- applies changes pushed by the server
+local function applyChanges(json) -- { revision, containers, added, modified, removed, diagnostics }
+	if json.containers and json.containers ~= "null" then
+		for _, v in pairs(json.containers) do
+			makeContainer(v)
+		end
+	end
+	if json.removed and json.removed ~= "null" then
+		for _, v in pairs(json.removed) do
+			removeScript(v)
+		end
+	end
+	for _, files in pairs { json.added, json.modified } do
+		if files and files ~= "null" then
+			for _, v in pairs(files) do
+				makeScript(v)
+			end
+		end
+	end
+	report(json.diagnostics)
+end
+
+local listening = 0 -- changes each time automatic syncing is toggled, so old loops stop
+
+buttons[3].Click:connect(function()
+	if listening % 2 == 1 then
+		listening = listening + 1
+		notify "Stopped syncing automatically."
+		return
+	end
+	listening = listening + 1
+	local id = listening
+	initiate()
+	notify "Syncing automatically!"
+
+	Spawn(function()
+		while listening == id do
+			-- blocks until something changes, or the timeout runs out
+			local ok, res = ypcall(function()
+				return HttpService:GetAsync(
+					url("/sync/changes?timeout=20&since=" .. revision .. "&" .. tick() * 10000)
+				)
+			end)
+			if listening ~= id then
+				break
+			end
+
+			if not ok then
+				listening = listening + 1
+				notify("Stopped syncing automatically! " .. failure(res))
+				print("Failed to sync:", res)
+				break
+			end
+
+			local json = HttpService:JSONDecode(res)
+			if json.revision ~= revision then
+				applyChanges(json)
+				revision = json.revision
+			end
+		end
+	end)
+end)
+
+local pairing
+
+buttons[4].Click:connect(function()
+	if pairing then
+		return
+	end
+
+	pairing = New "ScreenGui" {
+		Name = "Mercury Sync Pairing",
+		Parent = game.StarterGui,
+
+		[Children] = New "TextBox" {
+			Name = "Token",
+			BackgroundColor3 = Color3.new(0, 0, 0),
+			BackgroundTransparency = 0.5,
+			BorderSizePixel = 0,
+			Position = UDim2.new(0.5, -WIDTH / 2, 0.5, -25),
+			Size = UDim2.new(0, WIDTH, 0, 50),
+			ClearTextOnFocus = true,
+			Text = "Paste the pairing token here",
+			TextColor3 = Color3.new(1, 1, 1),
+			Font = Enum.Font.SourceSans,
+			FontSize = Enum.FontSize.Size18,
+		},
+	}
+
+	local box = pairing.Token
+	box.FocusLost:connect(function(enterPressed)
+		if enterPressed then
+			token = (box.Text:gsub("%s", ""))
+			plugin:SetSetting("MercurySyncToken", token)
+			notify "Paired with Mercury Sync Server!"
+		end
+		pairing:Destroy()
+		pairing = nil
+	end)
+	box:CaptureFocus()
+end)
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenHeader can be used instead of the token query parameter, though
+// older versions of HttpService can't set headers
+const TokenHeader = "X-Mercury-Token"
+
+// newToken generates a pairing token for when one isn't set in the config
+func newToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requireToken rejects requests that don't have the pairing token
+func requireToken(token string) gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		given := cx.GetHeader(TokenHeader)
+		if given == "" {
+			given = cx.Query("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			cx.AbortWithStatusJSON(401, gin.H{"error": "missing or incorrect pairing token"})
+			return
+		}
+		cx.Next()
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	c "github.com/TwiN/go-color"
+)
+
+// node is an instance in the tree being built
+type node struct {
+	name       string
+	className  string
+	source     *string
+	properties map[string]any
+	children   []*node
+}
+
+func (n *node) child(name string) *node {
+	for _, ch := range n.children {
+		if ch.name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+// find returns the node at a path, creating any that don't exist yet
+func (n *node) find(path []string, place bool) *node {
+	for i, name := range path {
+		ch := n.child(name)
+		if ch == nil {
+			// same as the plugin, services at the top of places and models below
+			ch = &node{name: name, className: "Model"}
+			if place && i == 0 {
+				ch.className = name
+			}
+			n.children = append(n.children, ch)
+		}
+		n = ch
+	}
+	return n
+}
+
+var scriptClasses = map[string]string{
+	"server": "Script",
+	"client": "LocalScript",
+	"module": "ModuleScript",
+}
+
+// buildTree arranges files and containers into an instance tree
+func buildTree(files []File, containers []Container, place bool) *node {
+	root := &node{}
+
+	for _, ct := range containers {
+		n := root.find(ct.Path, place)
+		n.className = ct.ClassName
+		n.properties = ct.Properties
+	}
+
+	for _, f := range files {
+		n := root.find(f.Path, place)
+		if f.Type == "instance" {
+			addInstance(n, *f.Instance)
+		} else {
+			content := f.Content
+			n.className = scriptClasses[f.Type]
+			n.source = &content
+		}
+		if f.Properties != nil {
+			if n.properties == nil {
+				n.properties = make(map[string]any)
+			}
+			for k, v := range f.Properties {
+				n.properties[k] = v
+			}
+		}
+	}
+
+	return root
+}
+
+func addInstance(n *node, inst Instance) {
+	n.className = inst.ClassName
+	n.properties = inst.Properties
+	for _, ch := range inst.Children {
+		child := n.child(ch.Name)
+		if child == nil {
+			child = &node{name: ch.Name}
+			n.children = append(n.children, child)
+		}
+		addInstance(child, ch)
+	}
+}
+
+// xmlWriter writes instances in Roblox's XML format
+type xmlWriter struct {
+	sb       strings.Builder
+	referent int
+}
+
+func (w *xmlWriter) text(s string) {
+	xml.EscapeText(&w.sb, []byte(s))
+}
+
+func (w *xmlWriter) item(n *node, depth int) error {
+	indent := strings.Repeat("\t", depth)
+
+	fmt.Fprintf(&w.sb, "%s<Item class=\"%s\" referent=\"RBX%d\">\n", indent, n.className, w.referent)
+	w.referent++
+	fmt.Fprintf(&w.sb, "%s\t<Properties>\n", indent)
+
+	fmt.Fprintf(&w.sb, "%s\t\t<string name=\"Name\">", indent)
+	w.text(n.name)
+	w.sb.WriteString("</string>\n")
+
+	if n.source != nil {
+		fmt.Fprintf(&w.sb, "%s\t\t<ProtectedString name=\"Source\"><![CDATA[%s]]></ProtectedString>\n",
+			indent, strings.ReplaceAll(*n.source, "]]>", "]]]]><![CDATA[>"))
+	}
+
+	// sorted, so builds are reproducible
+	var names []string
+	for k := range n.properties {
+		if k != "Name" && k != "Source" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if err := w.property(n.className, k, n.properties[k], indent+"\t\t"); err != nil {
+			return fmt.Errorf("%s.%s: %w", n.name, k, err)
+		}
+	}
+
+	fmt.Fprintf(&w.sb, "%s\t</Properties>\n", indent)
+	for _, ch := range n.children {
+		if err := w.item(ch, depth+1); err != nil {
+			return fmt.Errorf("%s.%w", n.name, err)
+		}
+	}
+	fmt.Fprintf(&w.sb, "%s</Item>\n", indent)
+	return nil
+}
+
+// components of typed property values, as sent to the plugin
+var valueTypes = map[string][]string{
+	"Vector3": {"X", "Y", "Z"},
+	"Vector2": {"X", "Y"},
+	"Color3":  {"R", "G", "B"},
+	"UDim2":   {"XS", "XO", "YS", "YO"},
+	"CFrame":  {"X", "Y", "Z", "R00", "R01", "R02", "R10", "R11", "R12", "R20", "R21", "R22"},
+}
+
+// properties stored as whole numbers, by class, with "" for every class.
+// Other numbers are floats, unless a model file says otherwise with
+// {"int": 5}.
+var intProperties = map[string]map[string]bool{
+	"":                    {"BrickColor": true},
+	"IntValue":            {"Value": true},
+	"IntConstrainedValue": {"Value": true, "MinValue": true, "MaxValue": true},
+}
+
+func (w *xmlWriter) property(className, name string, v any, indent string) error {
+	switch v := v.(type) {
+	case bool:
+		fmt.Fprintf(&w.sb, "%s<bool name=\"%s\">%t</bool>\n", indent, name, v)
+	case float64:
+		kind := "float"
+		if intProperties[""][name] || intProperties[className][name] {
+			kind = "int"
+		}
+		return w.number(kind, name, v, indent)
+	case string:
+		fmt.Fprintf(&w.sb, "%s<string name=\"%s\">", indent, name)
+		w.text(v)
+		w.sb.WriteString("</string>\n")
+	case map[string]any:
+		if len(v) != 1 {
+			return errors.New("typed values must have exactly one type")
+		}
+		for typeName, value := range v {
+			if typeName == "BrickColor" || typeName == "int" || typeName == "float" {
+				// BrickColor is stored as the colour's number
+				number, ok := value.(float64)
+				if !ok {
+					return errors.New(typeName + " must be a number")
+				}
+				if typeName == "float" {
+					return w.number("float", name, number, indent)
+				}
+				return w.number("int", name, number, indent)
+			}
+
+			fields, ok := valueTypes[typeName]
+			if !ok {
+				return errors.New("unknown property type " + typeName)
+			}
+			components, _ := value.([]any)
+			if typeName == "CFrame" && len(components) == 3 {
+				components = append(components, 1.0, 0.0, 0.0, 0.0, 1.0, 0.0, 0.0, 0.0, 1.0)
+			}
+			if len(components) != len(fields) {
+				return fmt.Errorf("%s needs %d components", typeName, len(fields))
+			}
+
+			tag := typeName
+			if typeName == "CFrame" {
+				tag = "CoordinateFrame"
+			}
+			fmt.Fprintf(&w.sb, "%s<%s name=\"%s\">", indent, tag, name)
+			for i, f := range fields {
+				fmt.Fprintf(&w.sb, "<%s>%v</%s>", f, components[i], f)
+			}
+			fmt.Fprintf(&w.sb, "</%s>\n", tag)
+		}
+	default:
+		return fmt.Errorf("unsupported value %v", v)
+	}
+	return nil
+}
+
+// number writes an int or float property
+func (w *xmlWriter) number(kind, name string, v float64, indent string) error {
+	if kind == "int" && v != math.Trunc(v) {
+		return fmt.Errorf("%v isn't a whole number", v)
+	}
+	fmt.Fprintf(&w.sb, "%s<%s name=\"%s\">%v</%s>\n", indent, kind, name, v, kind)
+	return nil
+}
+
+// BuildXML writes files and containers as a Roblox model, or a place if the
+// top level instances should be services
+func BuildXML(files []File, containers []Container, place bool) (string, error) {
+	root := buildTree(files, containers, place)
+
+	w := &xmlWriter{}
+	w.sb.WriteString(`<roblox xmlns:xmime="http://www.w3.org/2005/05/xmlmime" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.roblox.com/roblox.xsd" version="4">` + "\n")
+	w.sb.WriteString("\t<External>null</External>\n\t<External>nil</External>\n")
+	for _, ch := range root.children {
+		if err := w.item(ch, 1); err != nil {
+			return "", err
+		}
+	}
+	w.sb.WriteString("</roblox>\n")
+
+	return w.sb.String(), nil
+}
+
+// build compiles a target directory into a model or place file
+func build(target, output string, profile *Profile) error {
+	var place bool
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".rbxmx":
+	case ".rbxlx":
+		place = true
+	default:
+		return errors.New("output file must be .rbxmx or .rbxlx")
+	}
+
+	snapshot := NewSnapshot(target, profile)
+	snapshot.Scan()
+	var errs int
+	for _, d := range snapshot.Diagnostics() {
+		if d.Severity == "error" {
+			fmt.Println(c.InRed(d.String()))
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d file(s) failed to compile", errs)
+	}
+
+	files, containers := snapshot.Files()
+	for _, f := range files {
+		if f.Attributes != nil {
+			fmt.Println(c.InYellow("Attributes can't be built into XML files yet, skipping them for ") + c.InUnderline(c.InPurple(strings.Join(f.Path, "."))))
+		}
+	}
+
+	out, err := BuildXML(files, containers, place)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, []byte(out), 0o644)
+}
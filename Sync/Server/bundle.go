@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// extensions tried when resolving a require, in order
+var requireExts = []string{".lua", ".luau", ".moon", ".yue", ".json"}
+
+// bundleHeader is put on the first line of a bundled script, so the script's
+// own code stays on the same lines. Modules run once and are cached, like
+// ModuleScripts.
+const bundleHeader = "local __modules, __cache = {}, {} " +
+	"local function __require(name) local cached = __cache[name] if cached then return cached[1] end " +
+	"local value = __modules[name]() __cache[name] = { value } return value end " +
+	"local function __main(...) "
+
+// bundler inlines relative requires, like require("./util"), into the
+// script requiring them
+type bundler struct {
+	byPath  map[string]*entry // by path on disk
+	order   []string          // modules in the order they were first required
+	modules map[string]string // rewritten code of each module, by relative path
+}
+
+// bundle returns a script with every module it requires inlined into it,
+// or the script as it is if it doesn't require anything
+func bundle(e *entry, byPath map[string]*entry) (string, error) {
+	b := &bundler{byPath: byPath, modules: make(map[string]string)}
+	content, err := b.rewrite(e, []string{e.Rel})
+	if err != nil || len(b.order) == 0 {
+		return e.compiled, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(bundleHeader)
+	sb.WriteString(content)
+	sb.WriteString("\nend\n")
+	for _, key := range b.order {
+		sb.WriteString("__modules[" + luaString(key) + "] = function(...)\n")
+		sb.WriteString(b.modules[key])
+		sb.WriteString("\nend\n")
+	}
+	sb.WriteString("return __main(...)\n")
+	return sb.String(), nil
+}
+
+// rewrite replaces the relative requires in a script with calls to
+// __require, bundling the modules they require. chain is the requires that
+// led to the script, for errors.
+func (b *bundler) rewrite(e *entry, chain []string) (string, error) {
+	toks, err := lexLua(e.compiled)
+	if err != nil {
+		return "", requireError(chain, err)
+	}
+
+	content := e.compiled
+	// backwards, so offsets aren't moved by earlier replacements
+	for i := len(toks) - 1; i >= 0; i-- {
+		start, end, name, ok := requireAt(toks, i)
+		if !ok {
+			continue
+		}
+
+		dep, err := b.resolve(e, name)
+		if err != nil {
+			return "", requireError(chain, err)
+		}
+		for _, c := range chain {
+			if c == dep.Rel {
+				return "", fmt.Errorf("require cycle: %s -> %s", strings.Join(chain, " -> "), dep.Rel)
+			}
+		}
+
+		if _, done := b.modules[dep.Rel]; !done {
+			code, err := b.rewrite(dep, append(chain[:len(chain):len(chain)], dep.Rel))
+			if err != nil {
+				return "", err
+			}
+			b.modules[dep.Rel] = code
+			b.order = append(b.order, dep.Rel)
+		}
+		// keep any newlines in the require, so lines after it don't move
+		newlines := strings.Repeat("\n", strings.Count(content[start:end], "\n"))
+		content = content[:start] + "__require(" + luaString(dep.Rel) + ")" + newlines + content[end:]
+	}
+	return content, nil
+}
+
+// requireError says which module an error is in, if it isn't the script
+// being bundled itself
+func requireError(chain []string, err error) error {
+	if len(chain) == 1 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", strings.Join(chain, " -> "), err)
+}
+
+// requireAt finds a relative require starting at token i, returning where it
+// starts and ends in the source and the path it requires
+func requireAt(toks []token, i int) (start, end int, name string, ok bool) {
+	if toks[i].kind != tokName || toks[i].value != "require" {
+		return
+	}
+	if i > 0 && toks[i-1].kind == tokOp && (toks[i-1].value == "." || toks[i-1].value == ":") {
+		return
+	}
+
+	// require "./x" or require("./x")
+	arg := i + 1
+	parens := arg < len(toks) && toks[arg].kind == tokOp && toks[arg].value == "("
+	if parens {
+		arg++
+	}
+	if arg >= len(toks) || toks[arg].kind != tokString || !strings.ContainsAny(toks[arg].value[:1], `"'`) {
+		return
+	}
+	last := toks[arg]
+	if parens {
+		if arg+1 >= len(toks) || toks[arg+1].value != ")" {
+			return
+		}
+		last = toks[arg+1]
+	}
+
+	name, err := strconv.Unquote(`"` + toks[arg].value[1:len(toks[arg].value)-1] + `"`)
+	if err != nil || !strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../") {
+		return
+	}
+	return toks[i].offset, last.offset + len(last.value), name, true
+}
+
+// resolve finds the script a require refers to, relative to the script
+// requiring it
+func (b *bundler) resolve(from *entry, name string) (*entry, error) {
+	base := filepath.Join(filepath.Dir(from.Path), filepath.FromSlash(name))
+
+	candidates := []string{base}
+	for _, ext := range requireExts {
+		candidates = append(candidates, base+ext)
+	}
+	for _, ext := range requireExts {
+		candidates = append(candidates, filepath.Join(base, "init"+ext))
+	}
+
+	for _, path := range candidates {
+		if dep, ok := b.byPath[path]; ok && dep.file.Instance == nil {
+			return dep, nil
+		}
+	}
+	if _, err := os.Stat(base); err == nil {
+		return nil, fmt.Errorf("%q isn't a script being synced", name)
+	}
+	return nil, fmt.Errorf("can't find %q", name)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bundleFiles makes entries for scripts by their path relative to the target
+func bundleFiles(files map[string]string) map[string]*entry {
+	byPath := make(map[string]*entry)
+	for rel, content := range files {
+		path := filepath.Join("target", filepath.FromSlash(rel))
+		byPath[path] = &entry{Source: Source{Path: path, Rel: rel}, compiled: content}
+	}
+	return byPath
+}
+
+func TestBundle(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string // main.server.lua if not given
+		files   map[string]string
+		want    []string // in the output
+		notWant []string
+		err     string
+	}{
+		{
+			name:  "nothing to bundle",
+			files: map[string]string{"main.server.lua": "print(require(game.Workspace.M))\n"},
+			want:  []string{"print(require(game.Workspace.M))\n"},
+		},
+		{
+			name:  "relative requires",
+			entry: "src/main.server.lua",
+			files: map[string]string{
+				"src/main.server.lua": "local a = require(\"./a\")\nlocal b = require '../shared/b'\n",
+				"src/a.lua":           "return 1",
+				"shared/b.lua":        "return 2",
+				"src/b.lua":           "return 3",
+				"unrelated.lua":       "return 4",
+			},
+			want: []string{
+				`local a = __require("src/a.lua")`,
+				`local b = __require("shared/b.lua")`,
+				"__modules[\"src/a.lua\"] = function(...)\nreturn 1\nend\n",
+				"__modules[\"shared/b.lua\"] = function(...)\nreturn 2\nend\n",
+				"return __main(...)\n",
+			},
+			notWant: []string{"return 3", "return 4"},
+		},
+		{
+			name: "directories use their init file",
+			files: map[string]string{
+				"main.server.lua": "local lib = require(\"./lib\")",
+				"lib/init.luau":   "return {}",
+			},
+			want: []string{`__require("lib/init.luau")`, "__modules[\"lib/init.luau\"]"},
+		},
+		{
+			name: "modules are only included once",
+			files: map[string]string{
+				"main.server.lua": "require(\"./a\")\nrequire(\"./b\")",
+				"a.lua":           "return require(\"./c\")",
+				"b.lua":           "return require(\"./c\")",
+				"c.lua":           "return 'c'",
+			},
+			want: []string{"__modules[\"c.lua\"]"},
+		},
+		{
+			name: "other requires are left alone",
+			files: map[string]string{
+				"main.server.lua": "x.require(\"./a\")\nx:require(\"./a\")\nrequire(\"a\")\nrequire(script.a)\nlocal s = \"require('./a')\" -- require(\"./a\")",
+				"a.lua":           "return 1",
+			},
+			want: []string{"x.require(\"./a\")\nx:require(\"./a\")\nrequire(\"a\")\nrequire(script.a)\nlocal s = \"require('./a')\" -- require(\"./a\")"},
+		},
+		{
+			name: "cycles",
+			files: map[string]string{
+				"main.server.lua": "require(\"./a\")",
+				"a.lua":           "require(\"./b\")",
+				"b.lua":           "require(\"./a\")",
+			},
+			err: "require cycle: main.server.lua -> a.lua -> b.lua -> a.lua",
+		},
+		{
+			name: "requiring itself",
+			files: map[string]string{
+				"main.server.lua": "require(\"./main.server\")",
+			},
+			err: "require cycle: main.server.lua -> main.server.lua",
+		},
+		{
+			name:  "missing modules",
+			files: map[string]string{"main.server.lua": "require(\"./missing\")"},
+			err:   `can't find "./missing"`,
+		},
+		{
+			name: "errors in modules give the chain",
+			files: map[string]string{
+				"main.server.lua": "require(\"./a\")",
+				"a.lua":           "require(\"./b\")",
+				"b.lua":           "require(\"./missing\")",
+			},
+			err: `main.server.lua -> a.lua -> b.lua: can't find "./missing"`,
+		},
+		{
+			name: "modules that can't be lexed",
+			files: map[string]string{
+				"main.server.lua": "require(\"./a\")",
+				"a.lua":           "print(\"unfinished)",
+			},
+			err: "main.server.lua -> a.lua: line 1: unfinished string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.entry == "" {
+				tt.entry = "main.server.lua"
+			}
+			byPath := bundleFiles(tt.files)
+			main := byPath[filepath.Join("target", filepath.FromSlash(tt.entry))]
+
+			got, err := bundle(main, byPath)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output doesn't contain %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("output contains %q:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
+
+// the script being bundled keeps its lines, so source maps still work
+func TestBundleKeepsLines(t *testing.T) {
+	source := "local a = require(\"./a\")\n\nlocal b = require(\n\t\"./b\"\n)\nerror(\"marker\")\n"
+	byPath := bundleFiles(map[string]string{
+		"main.server.lua": source,
+		"a.lua":           "return 1\n",
+		"b.lua":           "return 2\n",
+	})
+
+	got, err := bundle(byPath[filepath.Join("target", "main.server.lua")], byPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := func(s string) int {
+		return strings.Count(s[:strings.Index(s, `error("marker")`)], "\n") + 1
+	}
+	if line(got) != line(source) {
+		t.Errorf("marker moved from line %d to %d:\n%s", line(source), line(got), got)
+	}
+}
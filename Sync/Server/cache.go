@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// darklua looks for its config in the working directory
+var darkluaConfigs = []string{".darklua.json", ".darklua.json5"}
+
+// darkluaConfig reads the darklua config a profile uses, returning an empty
+// name if there isn't one
+func darkluaConfig(p *Profile) (string, []byte, error) {
+	if p.Darklua != "" {
+		file, err := os.ReadFile(p.Darklua)
+		return p.Darklua, file, err
+	}
+	for _, name := range darkluaConfigs {
+		if file, err := os.ReadFile(name); err == nil {
+			return name, file, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// darkluaFiles returns where the darklua config a profile uses can be
+func darkluaFiles(p *Profile) []string {
+	if p.Darklua != "" {
+		return []string{p.Darklua}
+	}
+	return darkluaConfigs
+}
+
+// darkluaConfigHash identifies the darklua config a profile uses, so
+// scripts can be compiled again when it changes
+func darkluaConfigHash(p *Profile) string {
+	name, file, err := darkluaConfig(p)
+	if err != nil {
+		return err.Error()
+	}
+	return hash(name + "\x00" + string(file))
+}
+
+var (
+	versionsMu sync.Mutex
+	versions   = make(map[string]string)
+)
+
+// darkluaVersion asks darklua for its version, once per binary
+func darkluaVersion(path string) (string, error) {
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+
+	if v, ok := versions[path]; ok {
+		return v, nil
+	}
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(out))
+	versions[path] = v
+	return v, nil
+}
+
+// cacheKey identifies compiled output by everything that affects it: the
+// source, the version of darklua and its config
+func cacheKey(darklua string, source []byte, p *Profile) (string, error) {
+	version, err := darkluaVersion(darklua)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(version + "\x00"))
+	if retainLines(p) {
+		h.Write([]byte("retain_lines\x00"))
+	}
+	name, file, err := darkluaConfig(p)
+	if err != nil {
+		return "", err
+	}
+	h.Write([]byte(name + "\x00"))
+	h.Write(file)
+	h.Write([]byte("\x00"))
+	h.Write(source)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func cacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "mercury-sync", "darklua")
+}
+
+func readCache(key string) (string, bool) {
+	file, err := os.ReadFile(filepath.Join(cacheDir(), key+".lua"))
+	if err != nil {
+		return "", false
+	}
+	return string(file), true
+}
+
+// writeCache saves compiled output. Failing to is fine, it'll just be
+// compiled again next time.
+func writeCache(key, content string) {
+	dir := cacheDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+
+	// written to a temporary file first, so a half-written file is never read
+	temp, err := os.CreateTemp(dir, key+"-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = temp.WriteString(content)
+	temp.Close()
+	if err != nil {
+		os.Remove(temp.Name())
+		return
+	}
+	if os.Rename(temp.Name(), filepath.Join(dir, key+".lua")) != nil {
+		os.Remove(temp.Name())
+	}
+}
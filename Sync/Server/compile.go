@@ -1,24 +1,184 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
 )
 
-func CompileLuau(sourcePath string) (string, error) {
-	path, err := exec.LookPath("./tools/darklua")
+// darkluaPath is where darklua is expected to be
+const darkluaPath = "./tools/darklua"
+
+// useDarklua returns whether Luau files are compiled with darklua
+func useDarklua() bool {
+	switch config.Compiler {
+	case "native":
+		return false
+	case "darklua":
+		return true
+	}
+	_, err := exec.LookPath(darkluaPath)
+	return err == nil
+}
+
+// CompileLuau compiles a Luau file to Lua, using the compiler from the config
+func CompileLuau(sourcePath string, p *Profile) (string, error) {
+	if useDarklua() {
+		return compileDarklua(sourcePath, p)
+	}
+	return compileNative(sourcePath)
+}
+
+// luauLines maps compiled Luau back to its source. The native compiler
+// rewrites expressions in place, keeping the newlines in any that span lines,
+// and darklua keeps lines when it's made to.
+func luauLines(content string, p *Profile) []int {
+	if !useDarklua() || retainLines(p) {
+		return sameLines(content)
+	}
+	return nil
+}
+
+func compileDarklua(sourcePath string, p *Profile) (string, error) {
+	path, err := exec.LookPath(darkluaPath)
 	if err != nil {
 		return "", err
 	}
 
-	cmd := exec.Command(path, "process", sourcePath, "./temp.lua")
-	err = cmd.Run()
+	source, err := os.ReadFile(sourcePath)
 	if err != nil {
 		return "", err
 	}
+	key, err := cacheKey(path, source, p)
+	if err != nil {
+		return "", err
+	}
+	if cached, ok := readCache(key); ok {
+		return cached, nil
+	}
+
+	// Each compile gets its own output file, so they can run at the same time
+	temp, err := os.CreateTemp("", "mercury-sync-*.lua")
+	if err != nil {
+		return "", err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	args := []string{"process", sourcePath, temp.Name()}
+	if retainLines(p) {
+		configPath, err := retainLinesConfig(p)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(configPath)
+		args = append([]string{"process", "--config", configPath}, args[1:]...)
+	} else if p.Darklua != "" {
+		args = append([]string{"process", "--config", p.Darklua}, args[1:]...)
+	}
+
+	cmd := exec.Command(path, args...)
+	if _, err = run(cmd); err != nil {
+		return "", err
+	}
 
 	// Return the compiled file
-	file, _ := os.ReadFile("./temp.lua")
+	file, err := os.ReadFile(temp.Name())
+	if err != nil {
+		return "", err
+	}
+	writeCache(key, string(file))
 
 	return string(file), nil
 }
+
+// findTool looks for a compiler in the tools folder, then in PATH
+func findTool(name string) (string, error) {
+	if path, err := exec.LookPath("./tools/" + name); err == nil {
+		return path, nil
+	}
+	return exec.LookPath(name)
+}
+
+// run runs a compiler, returning what it printed, or what it printed as an
+// error if it failed
+func run(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String() + stdout.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// CompileMoonScript compiles a MoonScript file with moonc
+func CompileMoonScript(sourcePath string, _ *Profile) (string, error) {
+	path, err := findTool("moonc")
+	if err != nil {
+		return "", err
+	}
+	return run(exec.Command(path, "-p", sourcePath))
+}
+
+// CompileYueScript compiles a YueScript file with yue
+func CompileYueScript(sourcePath string, _ *Profile) (string, error) {
+	path, err := findTool("yue")
+	if err != nil {
+		return "", err
+	}
+	if config.SourceMaps {
+		// adds the source line as a comment to the end of each line
+		return run(exec.Command(path, "-l", "-p", sourcePath))
+	}
+	return run(exec.Command(path, "-p", sourcePath))
+}
+
+// job is a file that needs to be read or compiled
+type job struct {
+	src   Source
+	info  os.FileInfo
+	order int
+}
+
+type result struct {
+	file File
+	err  error
+}
+
+// readAll reads and compiles files using a pool of workers, returning the
+// results in the same order as the jobs
+func readAll(jobs []job) []result {
+	results := make([]result, len(jobs))
+
+	workers := min(runtime.NumCPU(), len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				file, err := readSource(jobs[i].src)
+				results[i] = result{file, err}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,24 @@
+//go:build cgo
+
+package main
+
+import (
+	"Luau/compat"
+	"errors"
+	"os"
+)
+
+// compileNative compiles Luau in-process, without needing darklua. The
+// parser is written in C, so it's only built with cgo.
+func compileNative(sourcePath string) (string, error) {
+	source, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	out, err := compat.Compatify(source)
+	var uerr *compat.UnsupportedError
+	if errors.As(err, &uerr) {
+		return "", &CompileError{sourcePath, uerr.Line, uerr.Column, uerr.Error()}
+	}
+	return out, err
+}
@@ -0,0 +1,90 @@
+//go:build cgo
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileNative(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+		err    *CompileError // File is left out
+	}{
+		{
+			name:   "plain Lua",
+			source: "local x = 1\nprint(x)",
+			want:   "local x = 1\nprint(x)",
+		},
+		{
+			name:   "annotations",
+			source: "local x: number, y: {string} = 1, {}\nfor i: number, v: string? in pairs(y) do end",
+			want:   "local x, y = 1, {}\nfor i, v in pairs(y) do end",
+		},
+		{
+			name:   "functions",
+			source: "local function f<T>(a: T, ...: number): (T, number) return a, ... end\nfunction t:m(b: (number) -> string): () end\nlocal g = function(c: any): string? end",
+			want:   "local function f(a, ...) return a, ... end\nfunction t:m(b) end\nlocal g = function(c) end",
+		},
+		{
+			name:   "casts",
+			source: "local y = (x :: any) + 1\nlocal z = x :: number",
+			want:   "local y = (x) + 1\nlocal z = x",
+		},
+		{
+			name:   "type definitions keep their lines",
+			source: "type Foo<T> = {\n\tx: T,\n}\nexport type Bar = number | string\nerror(\"marker\")",
+			want:   "\n\n\n\nerror(\"marker\")",
+		},
+		{
+			name:   "types inside converted expressions",
+			source: "local a = if x then (y :: number) // 2 else 0",
+			want:   "local a = (function()if x then return math.floor((y)/2) end return 0 end)()",
+		},
+		{
+			name:   "string interpolation",
+			source: "local a = 1\nprint(`{a}`)",
+			err:    &CompileError{Line: 2, Column: 7, Message: "string interpolation can't be converted to Lua without darklua"},
+		},
+		{
+			name:   "continue",
+			source: "for i = 1, 2 do\n\tcontinue\nend",
+			err:    &CompileError{Line: 2, Column: 2, Message: "continue can't be converted to Lua without darklua"},
+		},
+		{
+			name:   "compound assignment",
+			source: "x += 1",
+			err:    &CompileError{Line: 1, Column: 1, Message: "+= can't be converted to Lua without darklua"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.luau")
+			if err := os.WriteFile(path, []byte(tt.source), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := compileNative(path)
+			if tt.err != nil {
+				tt.err.File = path
+				var cerr *CompileError
+				if !errors.As(err, &cerr) || *cerr != *tt.err {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
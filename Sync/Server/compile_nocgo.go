@@ -0,0 +1,12 @@
+//go:build !cgo
+
+package main
+
+import "errors"
+
+var errNoNative = errors.New("this build of Mercury Sync was made without cgo, so has no native Luau compiler. Please place a copy of darklua in the tools folder.")
+
+// compileNative fails without cgo, which the native compiler's parser needs
+func compileNative(string) (string, error) {
+	return "", errNoNative
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ConfigFile is read from the working directory, next to the tools folder
+const ConfigFile = "mercury-sync.toml"
+
+// Config is the server's configuration, all of which is optional
+type Config struct {
+	// Compiler used for Luau files: "darklua", "native" or "auto", which uses
+	// darklua if it's in the tools folder and the native compiler otherwise.
+	// The native compiler converts if-expressions and floor division and
+	// removes types, without needing any external tools, though it's only
+	// in builds made with cgo.
+	Compiler string `toml:"compiler"`
+
+	// SourceMaps keeps compiled code on the same lines as its source where
+	// the compiler allows it, so errors in Studio can be traced back to the
+	// original file. darklua is made to use its retain_lines generator,
+	// unless its config sets a generator itself.
+	SourceMaps bool `toml:"sourcemaps"`
+
+	// GitIgnore skips files ignored by .gitignore files in the target, as
+	// well as those in .meltignore files.
+	GitIgnore bool `toml:"gitignore"`
+
+	// Lint checks scripts against the Mercury std before they're sent,
+	// warning about unknown globals, wrong argument counts and _SERVER or
+	// _CLIENT used in the wrong type of script.
+	Lint bool `toml:"lint"`
+
+	// Bundle inlines relative requires, like require("./util"), into the
+	// scripts requiring them, so shared code doesn't need a ModuleScript.
+	Bundle bool `toml:"bundle"`
+
+	// Substitutions are the values the Mercury launcher would fill in, such
+	// as _USER_ID or _PLACE_ID, so launcher scripts can be tested in Studio.
+	Substitutions map[string]any `toml:"substitutions"`
+
+	// substitutions as Lua literals
+	literals map[string]string
+
+	// Profiles are named sets of compile rules and substitutions, like a
+	// readable dev build and a minified release one. Profile is the one used
+	// when a request or build doesn't name one.
+	Profiles map[string]*Profile `toml:"profiles"`
+	Profile  string              `toml:"profile"`
+
+	// Token is the pairing token every request to the server must have. A
+	// new one is generated each time the server starts if it isn't set.
+	Token string `toml:"token"`
+
+	// Remote listens on every network interface instead of only loopback,
+	// so Studio can sync from another machine.
+	Remote bool `toml:"remote"`
+}
+
+// Profile is a set of compile rules and substitutions to build scripts with
+type Profile struct {
+	Name string `toml:"-"`
+
+	// Darklua is the darklua config to use instead of .darklua.json5
+	Darklua string `toml:"darklua"`
+
+	// Substitutions are used over the top of the ones for every profile
+	Substitutions map[string]any `toml:"substitutions"`
+
+	literals map[string]string
+}
+
+// findProfile returns a profile from the config, or the default one if name
+// is empty. Without any profile, scripts are built as the config says.
+func findProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = config.Profile
+	}
+	if name == "" {
+		return &Profile{literals: config.literals}, nil
+	}
+	p, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	return p, nil
+}
+
+var config = Config{
+	Compiler: "auto",
+}
+
+// LoadConfig reads the config file, keeping the defaults if it doesn't exist
+func LoadConfig(path string) error {
+	file, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := toml.Unmarshal(file, &config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch config.Compiler {
+	case "auto", "darklua", "native":
+	default:
+		return fmt.Errorf("%s: unknown compiler %q", path, config.Compiler)
+	}
+
+	if config.literals, err = substitutionLiterals(config.Substitutions); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range config.Profiles {
+		if p == nil {
+			p = &Profile{}
+			config.Profiles[name] = p
+		}
+		p.Name = name
+
+		values := make(map[string]any)
+		for k, v := range config.Substitutions {
+			values[k] = v
+		}
+		for k, v := range p.Substitutions {
+			values[k] = v
+		}
+		if p.literals, err = substitutionLiterals(values); err != nil {
+			return fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+	}
+	if _, err := findProfile(""); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Diagnostic is a problem with a file, sent to the plugin so it can show
+// which files failed and why
+type Diagnostic struct {
+	Severity string `json:"severity"` // "error", "warning" or "info"
+	File     string `json:"file"`     // relative to the target directory
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	pos := d.File
+	if d.Line > 0 {
+		pos += ":" + strconv.Itoa(d.Line)
+		if d.Column > 0 {
+			pos += ":" + strconv.Itoa(d.Column)
+		}
+	}
+	return pos + ": " + d.Severity + ": " + d.Message
+}
+
+// CompileError is an error at a known position in a file
+type CompileError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// jsonError adds the position to JSON syntax errors, which only have the
+// offset into the file
+func jsonError(path string, file []byte, err error) error {
+	var serr *json.SyntaxError
+	var terr *json.UnmarshalTypeError
+	var offset int64
+	switch {
+	case errors.As(err, &serr):
+		offset = serr.Offset - 1 // the offset is after the bad character
+	case errors.As(err, &terr):
+		offset = terr.Offset
+	default:
+		return err
+	}
+
+	before := file[:min(max(int(offset), 0), len(file))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return &CompileError{path, line, column, err.Error()}
+}
+
+// compilers don't agree on how to say where an error is, so try a few
+var positions = []*regexp.Regexp{
+	regexp.MustCompile(`:(\d+):(\d+):`),                        // file:line:column:
+	regexp.MustCompile(`(?i)\bline (\d+)(?:,? column (\d+))?`), // line 1, column 2
+	regexp.MustCompile(`\[(\d+)\] >>`),                         // moonc
+}
+
+// diagnose turns an error from reading or compiling a file into a diagnostic
+func diagnose(file string, err error) Diagnostic {
+	d := Diagnostic{Severity: "error", File: file, Message: err.Error()}
+
+	var cerr *CompileError
+	if errors.As(err, &cerr) {
+		d.Line, d.Column, d.Message = cerr.Line, cerr.Column, cerr.Message
+		return d
+	}
+
+	for _, re := range positions {
+		if m := re.FindStringSubmatch(d.Message); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			if len(m) > 2 {
+				d.Column, _ = strconv.Atoi(m[2])
+			}
+			break
+		}
+	}
+	return d
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	c "github.com/TwiN/go-color"
+)
+
+// File is a single script or instance, as sent to the plugin
+type File struct {
+	Path       []string       `json:"path"`
+	Content    string         `json:"content"`
+	Type       string         `json:"type"`
+	Instance   *Instance      `json:"instance,omitempty"` // only for the instance type
+	Properties map[string]any `json:"properties,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+
+	// Cleared are properties and attributes a meta file used to set, so
+	// Studio can put them back to how they'd be without it
+	Cleared           []string `json:"cleared,omitempty"`
+	ClearedAttributes []string `json:"clearedAttributes,omitempty"`
+
+	// Lines is the source line of each line of Content, or nil if the
+	// compiler moved lines around without saying where to
+	Lines []int `json:"-"`
+
+	Diagnostics []Diagnostic `json:"-"` // warnings found while compiling
+	Rewritten   bool         `json:"-"` // by substitutions or folding
+}
+
+// Hash identifies the content of a file, to tell when it has changed
+func (f File) Hash() string {
+	if f.Instance != nil {
+		b, _ := json.Marshal(f.Instance)
+		return hash(string(b))
+	}
+	return hash(f.Content)
+}
+
+// file types and the suffixes they're recognised by, longest first. Some
+// suffixes also give the script type.
+var fileTypes = []struct{ suffix, filetype, scripttype string }{
+	{".model.json", "model", ""},
+	{".meta.json", "meta", ""},
+	{".json", "json", ""},
+	{".txt", "txt", ""},
+	{".lua", "lua", ""},
+	{".luau", "luau", ""},
+	{".moon", "moon", ""},
+	{".yue", "yue", ""},
+}
+
+// isScript returns whether a file type can have a server/client suffix
+func isScript(filetype string) bool {
+	switch filetype {
+	case "lua", "luau", "moon", "yue":
+		return true
+	}
+	return false
+}
+
+// Source is a file found on disk, before it has been read or compiled
+type Source struct {
+	Path       string // path on disk
+	Rel        string // path relative to the target, separated by slashes
+	Key        string // instance path, separated by $dot$
+	FileType   string
+	ScriptType string
+	Init       bool
+	Profile    *Profile // set when scanning, as it isn't known from the path
+	Std        *Std     // to lint against, also set when scanning
+	Darklua    string   // hash of the darklua config, for Luau compiled with it
+}
+
+func (s Source) InstancePath() []string {
+	return strings.Split(s.Key, "$dot$")
+}
+
+func (s Source) Dotted() string {
+	return strings.ReplaceAll(s.Key, "$dot$", ".")
+}
+
+var (
+	errSkip        = errors.New("skipped")
+	errUnknownType = errors.New("unknown file type, skipping")
+	errNotMapped   = errors.New("not in any $path in " + ProjectFile + ", skipping")
+	errRoot        = errors.New("init files can't be synced to the root of the DataModel, skipping")
+)
+
+// findSource works out what a file on disk should be synced as, if anything.
+// rel is the path relative to the target directory, separated by slashes.
+func findSource(path, rel string, project *Project) (Source, error) {
+	if rel == ProjectFile {
+		return Source{}, errSkip
+	}
+
+	name := filepath.Base(path)
+	var filetype, scripttype string
+	for _, t := range fileTypes {
+		if strings.HasSuffix(strings.ToLower(name), t.suffix) {
+			filetype, scripttype = t.filetype, t.scripttype
+			// Trim extension from the file name
+			name = name[:len(name)-len(t.suffix)]
+			break
+		}
+	}
+	if filetype == "" {
+		return Source{}, errUnknownType
+	}
+
+	var instancePath, parts []string
+	if project != nil {
+		var ok bool
+		if instancePath, parts, ok = project.Resolve(rel); !ok {
+			return Source{}, errNotMapped
+		}
+	} else {
+		parts = strings.Split(rel, "/")
+	}
+
+	// Scripts without a server/client suffix are ModuleScripts, and so is JSON
+	if scripttype != "" {
+		// already known from the suffix
+	} else if scripttype = "module"; isScript(filetype) {
+		// remove suffix if it's a server/client script
+		for _, t := range []string{"server", "client"} {
+			if strings.HasSuffix(name, "."+t) {
+				scripttype = t
+				name = strings.TrimSuffix(name, "."+t)
+				break
+			}
+		}
+	} else if filetype == "meta" {
+		scripttype = "meta"
+	} else if filetype != "json" {
+		scripttype = "instance"
+	}
+
+	// A file mapped directly by the project takes the name of its mapping
+	init := false
+	if len(parts) > 0 {
+		init = name == "init" && scripttype != "instance"
+		instancePath = append(append([]string{}, instancePath...), parts[:len(parts)-1]...)
+		if !init {
+			instancePath = append(instancePath, name)
+		}
+	}
+	if len(instancePath) == 0 {
+		return Source{}, errRoot
+	}
+
+	return Source{
+		Path:       path,
+		Rel:        rel,
+		Key:        strings.Join(instancePath, "$dot$"),
+		FileType:   filetype,
+		ScriptType: scripttype,
+		Init:       init,
+	}, nil
+}
+
+type compiler struct {
+	language string
+	compile  func(sourcePath string, p *Profile) (string, error)
+	lines    func(content string, p *Profile) []int // maps the output back to the source, if possible
+	hint     string                                 // shown when the compiler can't be found
+}
+
+var compilers = map[string]compiler{
+	"luau": {"Luau", CompileLuau, luauLines, "Please place a copy of darklua (name \"darklua\" or \"darklua.exe\") in the tools folder, or set compiler = \"native\" in " + ConfigFile + "."},
+	"moon": {"MoonScript", CompileMoonScript, nil, "Please place a copy of moonc in the tools folder, or install MoonScript."},
+	"yue":  {"YueScript", CompileYueScript, yueLines, "Please place a copy of yue in the tools folder, or install YueScript."},
+}
+
+// readSource reads and compiles a file, ready to be sent to the plugin
+func readSource(s Source) (File, error) {
+	f := File{
+		Path: s.InstancePath(),
+		Type: s.ScriptType,
+	}
+
+	var content string
+
+	switch s.FileType {
+	case "luau", "moon", "yue":
+		comp := compilers[s.FileType]
+		info(c.InBlue("Compiling  ") + c.InUnderline(c.InPurple(s.Dotted())) + c.InBlue("..."))
+		var err error
+		content, err = comp.compile(s.Path, s.Profile)
+		if err != nil {
+			fmt.Println(c.InRed("Error while compiling "+comp.language+" file:"), err)
+			if comp.hint != "" && (errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)) {
+				fmt.Println(c.InYellow(comp.hint))
+			}
+			return File{}, err
+		}
+
+		if content == "" {
+			fmt.Println(c.InYellow("After compilation, file ") + c.InUnderline(c.InPurple(s.Dotted())) + c.InYellow(" was empty!"))
+			content = "-- Mercury Sync: Empty file"
+		} else if comp.lines != nil {
+			f.Lines = comp.lines(content, s.Profile)
+		}
+	default:
+		file, err := os.ReadFile(s.Path)
+		if err != nil {
+			fmt.Println(c.InRed("Error while reading file:"), err)
+			return File{}, err
+		}
+
+		switch s.FileType {
+		case "txt":
+			f.Instance = &Instance{
+				ClassName:  "StringValue",
+				Properties: map[string]any{"Value": strings.ReplaceAll(string(file), "\r\n", "\n")},
+			}
+			return f, nil
+		case "model":
+			if f.Instance, err = readModel(file); err != nil {
+				err = jsonError(s.Path, file, err)
+				fmt.Println(c.InRed("Error while reading model file:"), err)
+				return File{}, err
+			}
+			return f, nil
+		case "json":
+			if content, err = jsonToLua(file); err != nil {
+				err = jsonError(s.Path, file, err)
+				fmt.Println(c.InRed("Error while reading JSON file:"), err)
+				return File{}, err
+			}
+		default:
+			content = string(file)
+			f.Lines = sameLines(content)
+		}
+
+		if content == "" {
+			fmt.Println(c.InYellow("File ") + c.InUnderline(c.InPurple(s.Dotted())) + c.InYellow(" is empty!"))
+			content = "-- Mercury Sync: Empty file"
+		}
+	}
+
+	f.Content = strings.ReplaceAll(content, "\r\n", "\n")
+	if config.Lint && isScript(s.FileType) && s.Std != nil {
+		// columns are only right if the file wasn't compiled
+		f.Diagnostics = lint(f, s.Rel, s.FileType == "lua", s.Std)
+	}
+	if isScript(s.FileType) {
+		// a file that can't be lexed fails in Studio anyway
+		content, _ := substitute(f.Content, s.Profile.literals)
+		content, _ = fold(content, s.ScriptType)
+		f.Rewritten = content != f.Content
+		f.Content = content
+	}
+	return f, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import "strings"
+
+// clause is one branch of an if statement: if, elseif or else
+type clause struct {
+	start int    // index of the if, elseif or else token
+	then  int    // index of the then token, or start for else
+	value string // "true" or "false" if the condition is constant
+}
+
+// fold replaces _SERVER and _CLIENT with whether a script is a server or
+// client script, unless a local shadows them, then removes the branches of
+// if statements that can never run. Lines stay where they are, so source
+// maps and lint warnings still line up. ModuleScripts can run on either, so
+// are left alone.
+func fold(content, scriptType string) (string, error) {
+	if scriptType != "server" && scriptType != "client" {
+		return content, nil
+	}
+	content, err := substitute(content, map[string]string{
+		"_SERVER": boolString(scriptType == "server"),
+		"_CLIENT": boolString(scriptType == "client"),
+	})
+	if err != nil {
+		return content, err
+	}
+
+	// one if statement at a time, as each changes the offsets of the rest
+	for {
+		toks, err := lexLua(content)
+		if err != nil {
+			return content, err
+		}
+		folded, ok := foldIf(content, toks)
+		if !ok {
+			return content, nil
+		}
+		content = folded
+	}
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// foldIf folds the first if statement with a constant condition, returning
+// false if there aren't any
+func foldIf(content string, toks []token) (string, bool) {
+	for i, t := range toks {
+		if t.kind != tokKeyword || t.value != "if" {
+			continue
+		}
+		clauses, end, ok := ifClauses(toks, i)
+		if !ok {
+			return content, false
+		}
+
+		// else always runs if it's reached, so doesn't count
+		constant := false
+		for _, cl := range clauses {
+			constant = constant || cl.value != "" && cl.then != cl.start
+		}
+		if constant {
+			return foldClauses(content, toks, clauses, end), true
+		}
+	}
+	return content, false
+}
+
+// ifClauses finds the clauses of the if statement at i, and the index of its
+// end token
+func ifClauses(toks []token, i int) ([]clause, int, bool) {
+	var clauses []clause
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		t := toks[j]
+		if t.kind != tokKeyword {
+			continue
+		}
+
+		switch t.value {
+		case "function", "do", "repeat":
+			depth++
+		case "until":
+			depth--
+		case "if":
+			if j != i {
+				depth++
+				continue
+			}
+			fallthrough
+		case "elseif":
+			if depth > 0 {
+				continue
+			}
+			cl := clause{start: j}
+			for cl.then = j + 1; cl.then < len(toks) && !(toks[cl.then].kind == tokKeyword && toks[cl.then].value == "then"); cl.then++ {
+			}
+			if cl.then >= len(toks) {
+				return nil, 0, false
+			}
+			cl.value = constantCondition(toks[j+1 : cl.then])
+			clauses = append(clauses, cl)
+			j = cl.then
+		case "else":
+			if depth == 0 {
+				clauses = append(clauses, clause{start: j, then: j, value: "true"})
+			}
+		case "end":
+			if depth == 0 {
+				return clauses, j, true
+			}
+			depth--
+		}
+	}
+	return nil, 0, false
+}
+
+// constantCondition returns "true" or "false" if a condition is one of them,
+// or not one of them
+func constantCondition(cond []token) string {
+	negate := false
+	if len(cond) == 2 && cond[0].kind == tokKeyword && cond[0].value == "not" {
+		negate = true
+		cond = cond[1:]
+	}
+	if len(cond) != 1 || cond[0].kind != tokKeyword || cond[0].value != "true" && cond[0].value != "false" {
+		return ""
+	}
+	return boolString((cond[0].value == "true") != negate)
+}
+
+// foldClauses rewrites an if statement without the clauses that can never
+// run. Clauses after one that always runs are removed, and if that's the
+// first one left, the statement becomes a do block.
+func foldClauses(content string, toks []token, clauses []clause, end int) string {
+	var sb strings.Builder
+	sb.WriteString(content[:toks[clauses[0].start].offset])
+
+	kept := 0
+	for n, cl := range clauses {
+		stop := toks[end].offset
+		if n+1 < len(clauses) {
+			stop = toks[clauses[n+1].start].offset
+		}
+		start, then := toks[cl.start], toks[cl.then]
+		header := content[start.offset : then.offset+len(then.value)]
+		body := content[then.offset+len(then.value) : stop]
+
+		switch {
+		case cl.value == "false":
+			sb.WriteString(blank(header + body))
+			continue
+		case cl.value == "true" && kept == 0:
+			sb.WriteString("do" + blank(header))
+		case cl.value == "true":
+			sb.WriteString("else" + blank(header))
+		case kept == 0 && start.value == "elseif":
+			sb.WriteString(header[4:])
+		default:
+			sb.WriteString(header)
+		}
+		sb.WriteString(body)
+		kept++
+
+		if cl.value == "true" {
+			// nothing after this can run
+			sb.WriteString(blank(content[stop:toks[end].offset]))
+			break
+		}
+	}
+
+	// without any clauses left, the whole statement is gone
+	if kept > 0 {
+		sb.WriteString("end")
+	}
+	sb.WriteString(content[toks[end].offset+3:])
+	return sb.String()
+}
+
+// blank replaces code with the newlines in it, so the lines after it don't
+// move
+func blank(s string) string {
+	return strings.Repeat("\n", strings.Count(s, "\n"))
+}
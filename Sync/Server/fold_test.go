@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name       string
+		scriptType string
+		source     string
+		want       string
+	}{
+		{
+			name:       "branch that always runs",
+			scriptType: "server",
+			source:     "if _SERVER then a() end",
+			want:       "do a() end",
+		},
+		{
+			name:       "branch that never runs",
+			scriptType: "client",
+			source:     "x() if _SERVER then a() end y()",
+			want:       "x()  y()",
+		},
+		{
+			name:       "call form",
+			scriptType: "client",
+			source:     "if _CLIENT() then a() end",
+			want:       "do a() end",
+		},
+		{
+			name:       "negated",
+			scriptType: "server",
+			source:     "if not _SERVER then a() else b() end",
+			want:       "do b() end",
+		},
+		{
+			name:       "else after a branch that never runs",
+			scriptType: "server",
+			source:     "if _CLIENT then a() else b() end",
+			want:       "do b() end",
+		},
+		{
+			name:       "elseif becomes the first branch",
+			scriptType: "server",
+			source:     "if _CLIENT then a() elseif x then b() else c() end",
+			want:       "if x then b() else c() end",
+		},
+		{
+			name:       "elseif that never runs",
+			scriptType: "client",
+			source:     "if x then a() elseif _SERVER then b() elseif y then c() end",
+			want:       "if x then a() elseif y then c() end",
+		},
+		{
+			name:       "elseif that always runs becomes else",
+			scriptType: "client",
+			source:     "if x then a() elseif _CLIENT then b() elseif y then c() else d() end",
+			want:       "if x then a() else b() end",
+		},
+		{
+			name:       "nested blocks in a branch that never runs",
+			scriptType: "server",
+			source:     "if _CLIENT then local f = function() if x then return end end repeat f() until y for i = 1, 2 do end else b() end",
+			want:       "do b() end",
+		},
+		{
+			name:       "nested blocks in a branch that always runs",
+			scriptType: "server",
+			source:     "if _SERVER then repeat x() until y while z do end elseif w then v() end",
+			want:       "do repeat x() until y while z do end end",
+		},
+		{
+			name:       "constant ifs inside a kept branch",
+			scriptType: "server",
+			source:     "if _SERVER then if _CLIENT then a() end b() end",
+			want:       "do  b() end",
+		},
+		{
+			name:       "inside a function",
+			scriptType: "client",
+			source:     "local function f() if _SERVER then return 1 end return 2 end",
+			want:       "local function f()  return 2 end",
+		},
+		{
+			name:       "other conditions are left alone",
+			scriptType: "server",
+			source:     "if _SERVER and x then a() end if x then b() elseif y then c() end",
+			want:       "if true and x then a() end if x then b() elseif y then c() end",
+		},
+		{
+			name:       "fields aren't substituted",
+			scriptType: "server",
+			source:     "if t._SERVER then a() end",
+			want:       "if t._SERVER then a() end",
+		},
+		{
+			name:       "parameters named after them",
+			scriptType: "server",
+			source:     "local function f(_SERVER) if _SERVER then a() end end",
+			want:       "local function f(_SERVER) if _SERVER then a() end end",
+		},
+		{
+			name:       "locals shadowing them",
+			scriptType: "client",
+			source:     "local _SERVER = x if _SERVER then a() end",
+			want:       "local _SERVER = x if _SERVER then a() end",
+		},
+		{
+			name:       "outside the local's scope",
+			scriptType: "client",
+			source:     "do local _SERVER = x end if _SERVER then a() end",
+			want:       "do local _SERVER = x end ",
+		},
+		{
+			name:       "modules can run on either",
+			scriptType: "module",
+			source:     "if _SERVER then a() end",
+			want:       "if _SERVER then a() end",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fold(tt.source, tt.scriptType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// every line stays where it was, so source maps and lint warnings line up
+func TestFoldKeepsLines(t *testing.T) {
+	source := `local x = 1
+if _SERVER then
+	print("server")
+	local function f()
+		if y then
+			return
+		end
+	end
+elseif x then
+	print("x")
+else
+	print("other")
+end
+if _CLIENT
+then
+	print("client")
+end
+error("marker")
+`
+	for _, scriptType := range []string{"server", "client"} {
+		got, err := fold(source, scriptType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(got, "\n") != strings.Count(source, "\n") {
+			t.Errorf("%s: %d lines, want %d:\n%s", scriptType, strings.Count(got, "\n"), strings.Count(source, "\n"), got)
+		}
+		sourceLines, gotLines := strings.Split(source, "\n"), strings.Split(got, "\n")
+		for _, marker := range []string{`print("server")`, `print("client")`, `print("x")`, `error("marker")`} {
+			for i, line := range gotLines {
+				if strings.Contains(line, marker) && !strings.Contains(sourceLines[i], marker) {
+					t.Errorf("%s: %s moved to line %d", scriptType, marker, i+1)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile lists files that shouldn't be synced, in the same format as
+// .gitignore. Each directory can have its own.
+const IgnoreFile = ".meltignore"
+
+type ignoreRule struct {
+	base     string // directory of the file the rule is from, or "" for the target
+	pattern  []string
+	anchored bool // only matches relative to base, rather than any file name
+	negate   bool
+	dirOnly  bool
+}
+
+// Ignore is the rules from every ignore file found so far, in the order
+// they're checked. The last rule that matches wins, like git.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+// hidden files and directories, like .git or .vscode, are never synced,
+// though an ignore file can bring them back with a negated rule
+var defaultIgnore = []string{".*"}
+
+// NewIgnore returns the rules that apply even without an ignore file
+func NewIgnore() *Ignore {
+	ig := &Ignore{}
+	for _, line := range defaultIgnore {
+		ig.add("", line)
+	}
+	return ig
+}
+
+// Load reads the ignore files in a directory, which apply to everything
+// below it. rel is the directory relative to the target.
+func (ig *Ignore) Load(dir, rel string) error {
+	names := []string{IgnoreFile}
+	if config.GitIgnore {
+		names = append([]string{".gitignore"}, names...)
+	}
+	if rel == "." {
+		rel = ""
+	}
+
+	for _, name := range names {
+		file, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			ig.add(rel, scanner.Text())
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ig *Ignore) add(base, line string) {
+	line = strings.TrimRight(line, " \r")
+	if line == "" || line[0] == '#' {
+		return
+	}
+
+	rule := ignoreRule{base: base}
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		// \# and \! are a literal # or !
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return
+	}
+
+	// patterns with a slash anywhere but the end are relative to the file
+	rule.anchored = strings.Contains(line, "/")
+	rule.pattern = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	ig.rules = append(ig.rules, rule)
+}
+
+// Match returns whether a file or directory, relative to the target, is
+// ignored
+func (ig *Ignore) Match(rel string, dir bool) bool {
+	ignored := false
+	for _, rule := range ig.rules {
+		if rule.negate != ignored || rule.dirOnly && !dir {
+			// can't change the result
+			continue
+		}
+
+		p := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			p = rel[len(rule.base)+1:]
+		}
+
+		var ok bool
+		if rule.anchored {
+			ok = matchParts(rule.pattern, strings.Split(p, "/"))
+		} else {
+			ok, _ = path.Match(rule.pattern[0], path.Base(p))
+		}
+		if ok {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestIgnoreMatch(t *testing.T) {
+	type file struct {
+		rel  string
+		dir  bool
+		want bool
+	}
+	tests := []struct {
+		name  string
+		base  string // directory the rules are from
+		rules []string
+		files []file
+	}{
+		{
+			name:  "file names match at any depth",
+			rules: []string{"*.tmp"},
+			files: []file{{"a.tmp", false, true}, {"src/deep/a.tmp", false, true}, {"a.lua", false, false}},
+		},
+		{
+			name:  "leading slash anchors to the target",
+			rules: []string{"/build"},
+			files: []file{{"build", true, true}, {"src/build", true, false}},
+		},
+		{
+			name:  "slash in the middle anchors too",
+			rules: []string{"docs/*.md"},
+			files: []file{{"docs/a.md", false, true}, {"src/docs/a.md", false, false}, {"docs/sub/a.md", false, false}},
+		},
+		{
+			name:  "double star matches any number of directories",
+			rules: []string{"src/**/*.spec.lua"},
+			files: []file{{"src/a.spec.lua", false, true}, {"src/x/y/a.spec.lua", false, true}, {"lib/a.spec.lua", false, false}},
+		},
+		{
+			name:  "negation re-includes files",
+			rules: []string{"*.lua", "!keep.lua"},
+			files: []file{{"a.lua", false, true}, {"keep.lua", false, false}, {"src/keep.lua", false, false}},
+		},
+		{
+			name:  "last matching rule wins",
+			rules: []string{"!keep.lua", "*.lua"},
+			files: []file{{"keep.lua", false, true}},
+		},
+		{
+			name:  "anchored negation only re-includes its own path",
+			rules: []string{"*.lua", "!/src/keep.lua"},
+			files: []file{{"src/keep.lua", false, false}, {"lib/keep.lua", false, true}},
+		},
+		{
+			name:  "trailing slash only matches directories",
+			rules: []string{"out/"},
+			files: []file{{"out", true, true}, {"out", false, false}},
+		},
+		{
+			name:  "comments, blank lines and escapes",
+			rules: []string{"# a.lua", "", `\#b.lua`, `\!c.lua`},
+			files: []file{{"a.lua", false, false}, {"#b.lua", false, true}, {"!c.lua", false, true}},
+		},
+		{
+			name:  "rules from a subdirectory only apply below it",
+			base:  "src",
+			rules: []string{"*.txt", "/top.lua"},
+			files: []file{{"src/a.txt", false, true}, {"a.txt", false, false}, {"src/top.lua", false, true}, {"src/x/top.lua", false, false}, {"top.lua", false, false}},
+		},
+		{
+			name:  "hidden files are always ignored",
+			files: []file{{".git", true, true}, {"sub/.git", true, true}, {".vscode", true, true}, {"sub/.settings.json", false, true}, {"a.lua", false, false}},
+		},
+		{
+			name:  "unless an ignore file brings them back",
+			rules: []string{"!.hidden.lua"},
+			files: []file{{".hidden.lua", false, false}, {".other.lua", false, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ig := NewIgnore()
+			for _, line := range tt.rules {
+				ig.add(tt.base, line)
+			}
+			for _, f := range tt.files {
+				if got := ig.Match(f.rel, f.dir); got != f.want {
+					t.Errorf("Match(%q, %v) = %v, want %v", f.rel, f.dir, got, f.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	c "github.com/TwiN/go-color"
+)
+
+// The lint and format configs are copies of the ones at the root of the repo,
+// which TestTemplatesMatch keeps them in line with
+//go:generate cp ../../mercury.yml ../../selene.toml ../../stylua.toml ../../aftman.toml templates/
+//go:generate cp .darklua.json5 templates/
+
+//go:embed all:templates
+var templates embed.FS
+
+// dirs every new project has, which have nothing in them to start with
+var initDirs = []string{"tools"}
+
+// scaffold writes the templates to a new project, leaving any files that
+// already exist alone unless force is set
+func scaffold(target string, project, force bool) error {
+	for _, dir := range initDirs {
+		if err := os.MkdirAll(filepath.Join(target, dir), 0o755); err != nil {
+			return err
+		}
+	}
+
+	return fs.WalkDir(templates, "templates", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel := name[len("templates/"):]
+		if rel == ProjectFile && !project {
+			return nil
+		}
+
+		dest := filepath.Join(target, filepath.FromSlash(rel))
+		if _, err := os.Stat(dest); err == nil && !force {
+			info(c.InYellow("Skipping   ") + c.InUnderline(c.InPurple(rel)) + c.InYellow(", it already exists"))
+			return nil
+		}
+
+		file, err := templates.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		info(c.InGreen("Creating   ") + c.InUnderline(c.InPurple(path.Clean(rel))))
+		return os.WriteFile(dest, file, 0o644)
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// The templates can't embed files from outside the module, so they have
+// copies that go generate updates
+func TestTemplatesMatch(t *testing.T) {
+	copies := map[string]string{
+		"../../mercury.yml": "templates/mercury.yml",
+		"../../selene.toml": "templates/selene.toml",
+		"../../stylua.toml": "templates/stylua.toml",
+		"../../aftman.toml": "templates/aftman.toml",
+		".darklua.json5":    "templates/.darklua.json5",
+	}
+	for original, copied := range copies {
+		want, err := os.ReadFile(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := templates.ReadFile(copied)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date with %s, run go generate", copied, original)
+		}
+	}
+}
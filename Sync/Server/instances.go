@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Instance is a description of a non-script instance tree. Property values
+// are either JSON primitives, or objects with a single key naming their type,
+// such as {"Vector3": [0, 1, 0]} or {"int": 5}, which are converted by the
+// plugin.
+type Instance struct {
+	ClassName  string         `json:"className"`
+	Name       string         `json:"name,omitempty"`
+	Properties map[string]any `json:"properties,omitempty"`
+	Children   []Instance     `json:"children,omitempty"`
+}
+
+// readModel reads a .model.json file. Only the root may leave out its name,
+// as it's named after the file.
+func readModel(file []byte) (*Instance, error) {
+	var inst Instance
+	if err := json.Unmarshal(file, &inst); err != nil {
+		return nil, err
+	}
+	if err := inst.validate(true); err != nil {
+		return nil, err
+	}
+	return &inst, nil
+}
+
+func (inst Instance) validate(root bool) error {
+	if inst.ClassName == "" {
+		return errors.New("instance " + inst.Name + " has no className")
+	}
+	if !root && inst.Name == "" {
+		return errors.New("child of class " + inst.ClassName + " has no name")
+	}
+	for _, child := range inst.Children {
+		if err := child.validate(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonToLua converts a JSON document to a ModuleScript that returns it
+func jsonToLua(file []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(file))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return "", errors.New("invalid JSON: more than one value")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("return ")
+	writeLua(&sb, v, 0)
+	sb.WriteString("\n")
+	return sb.String(), nil
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || luaKeywords[s] {
+		return false
+	}
+	for i, ch := range s {
+		if !(ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || i > 0 && ch >= '0' && ch <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLua(sb *strings.Builder, v any, depth int) {
+	indent := strings.Repeat("\t", depth+1)
+
+	switch v := v.(type) {
+	case nil:
+		sb.WriteString("nil")
+	case bool:
+		fmt.Fprint(sb, v)
+	case json.Number:
+		sb.WriteString(v.String())
+	case string:
+		sb.WriteString(luaString(v))
+	case []any:
+		if len(v) == 0 {
+			sb.WriteString("{}")
+			return
+		}
+		sb.WriteString("{\n")
+		for _, item := range v {
+			sb.WriteString(indent)
+			writeLua(sb, item, depth+1)
+			sb.WriteString(",\n")
+		}
+		sb.WriteString(indent[1:] + "}")
+	case map[string]any:
+		if len(v) == 0 {
+			sb.WriteString("{}")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		sb.WriteString("{\n")
+		for _, k := range keys {
+			sb.WriteString(indent)
+			if isIdentifier(k) {
+				sb.WriteString(k)
+			} else {
+				sb.WriteString("[" + luaString(k) + "]")
+			}
+			sb.WriteString(" = ")
+			writeLua(sb, v[k], depth+1)
+			sb.WriteString(",\n")
+		}
+		sb.WriteString(indent[1:] + "}")
+	}
+}
+
+// luaString quotes a string using only escapes Lua 5.1 understands
+func luaString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(ch)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if ch < 0x20 || ch == 0x7f {
+				fmt.Fprintf(&sb, `\%03d`, ch)
+			} else {
+				sb.WriteByte(ch)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kinds of Lua token
+const (
+	tokName = iota
+	tokKeyword
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind   int
+	value  string
+	line   int
+	column int
+	offset int // in bytes from the start of the source
+}
+
+// operators, longest first so they're matched before their prefixes
+var luaOps = []string{
+	"...", "..=",
+	"..", "==", "~=", "<=", ">=", "::", "//", "+=", "-=", "*=", "/=", "%=", "^=", "->",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=", "(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+// lexLua splits Lua source into tokens, leaving out comments
+func lexLua(source string) ([]token, error) {
+	var tokens []token
+	line, lineStart := 1, 0
+
+	for i := 0; i < len(source); {
+		ch := source[i]
+		start, startLine, column := i, line, i-lineStart+1
+		add := func(kind int) {
+			tokens = append(tokens, token{kind, source[start:i], startLine, column, start})
+		}
+
+		switch {
+		case ch == '\n':
+			i++
+			line, lineStart = line+1, i
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+
+		case strings.HasPrefix(source[i:], "--"):
+			i += 2
+			if level := longBracket(source[i:]); level >= 0 {
+				end, lines, ok := skipLong(source[i:], level)
+				if !ok {
+					return nil, fmt.Errorf("line %d: unfinished long comment", startLine)
+				}
+				i += end
+				line += lines
+				if lines > 0 {
+					lineStart = strings.LastIndexByte(source[:i], '\n') + 1
+				}
+			} else {
+				for i < len(source) && source[i] != '\n' {
+					i++
+				}
+			}
+
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			for i < len(source) && isNameChar(source[i]) {
+				i++
+			}
+			if luaKeywords[source[start:i]] {
+				add(tokKeyword)
+			} else {
+				add(tokName)
+			}
+
+		case ch >= '0' && ch <= '9' || ch == '.' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9':
+			for i < len(source) && (isNameChar(source[i]) || source[i] == '.' ||
+				(source[i] == '+' || source[i] == '-') && strings.ContainsRune("eEpP", rune(source[i-1]))) {
+				i++
+			}
+			add(tokNumber)
+
+		case ch == '"' || ch == '\'':
+			i++
+			for i < len(source) && source[i] != ch {
+				if source[i] == '\n' {
+					return nil, fmt.Errorf("line %d: unfinished string", startLine)
+				}
+				if source[i] == '\\' {
+					i++
+					if i < len(source) && source[i] == '\n' {
+						line, lineStart = line+1, i+1
+					}
+				}
+				i++
+			}
+			if i >= len(source) {
+				return nil, fmt.Errorf("line %d: unfinished string", startLine)
+			}
+			i++
+			add(tokString)
+
+		case ch == '[' && longBracket(source[i:]) >= 0:
+			end, lines, ok := skipLong(source[i:], longBracket(source[i:]))
+			if !ok {
+				return nil, fmt.Errorf("line %d: unfinished long string", startLine)
+			}
+			i += end
+			line += lines
+			if lines > 0 {
+				lineStart = strings.LastIndexByte(source[:i], '\n') + 1
+			}
+			add(tokString)
+
+		default:
+			found := false
+			for _, op := range luaOps {
+				if strings.HasPrefix(source[i:], op) {
+					i += len(op)
+					add(tokOp)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("line %d: unexpected character %q", startLine, ch)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func isNameChar(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// longBracket returns the level of a long bracket like [==[, or -1 if s
+// doesn't start with one
+func longBracket(s string) int {
+	if !strings.HasPrefix(s, "[") {
+		return -1
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1
+	}
+	return -1
+}
+
+// skipLong finds the end of a long string or comment, returning its length
+// and how many lines it covers
+func skipLong(s string, level int) (int, int, bool) {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(s[level+2:], closing)
+	if end < 0 {
+		return 0, 0, false
+	}
+	end += level + 2 + len(closing)
+	return end, strings.Count(s[:end], "\n"), true
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// linter checks a file's use of globals against the Mercury std
+type linter struct {
+	scope
+	std        *Std
+	scriptType string
+	globals    map[string]bool // assigned to somewhere in the file
+	problems   []problem
+}
+
+type problem struct {
+	line, column int
+	message      string
+}
+
+// lint checks compiled Lua against the Mercury std, returning warnings for
+// unknown globals, wrong argument counts and misused substitutions. Lines
+// are mapped back to the source where possible.
+func lint(f File, rel string, columns bool, std *Std) []Diagnostic {
+	toks, err := lexLua(f.Content)
+	if err != nil {
+		return []Diagnostic{{Severity: "warning", File: rel, Message: "couldn't lint: " + err.Error()}}
+	}
+
+	l := &linter{
+		scope:      newScope(toks),
+		std:        std,
+		scriptType: f.Type,
+		globals:    assignedGlobals(toks),
+	}
+	l.walk(l.reference)
+
+	var diagnostics []Diagnostic
+	seen := make(map[string]bool)
+	for _, p := range l.problems {
+		d := Diagnostic{Severity: "warning", File: rel, Message: p.message}
+		if f.Lines != nil && p.line <= len(f.Lines) {
+			d.Line = f.Lines[p.line-1]
+			if columns {
+				d.Column = p.column
+			}
+		}
+		if key := d.String(); !seen[key] {
+			seen[key] = true
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+// assignedGlobals finds names assigned to outside of a local statement,
+// which are globals defined by the file itself
+func assignedGlobals(toks []token) map[string]bool {
+	globals := make(map[string]bool)
+	depth := 0
+	for i, t := range toks {
+		switch {
+		case t.kind == tokOp && strings.Contains("({[", t.value):
+			depth++
+		case t.kind == tokOp && strings.Contains(")}]", t.value):
+			depth--
+		case t.kind == tokName && depth == 0:
+			prev := ""
+			if i > 0 {
+				prev = toks[i-1].value
+			}
+			if prev == "function" && (i < 2 || toks[i-2].value != "local") {
+				globals[t.value] = true
+				continue
+			}
+			if prev == "." || prev == ":" || prev == "," || prev == "local" || prev == "for" {
+				continue
+			}
+
+			// a, b, c = ...
+			names := []string{t.value}
+			k := i
+			for k+2 < len(toks) && toks[k+1].value == "," && toks[k+2].kind == tokName {
+				k += 2
+				names = append(names, toks[k].value)
+			}
+			if k+1 < len(toks) && toks[k+1].kind == tokOp && toks[k+1].value == "=" {
+				for _, name := range names {
+					globals[name] = true
+				}
+			}
+		}
+	}
+	return globals
+}
+
+func (l *linter) problem(t token, format string, args ...any) {
+	l.problems = append(l.problems, problem{t.line, t.column, fmt.Sprintf(format, args...)})
+}
+
+// reference checks a global and the fields indexed on it, such as
+// CFrame.new, returning the index of the token after them
+func (l *linter) reference(i int) int {
+	t := l.toks[i]
+	names := []string{t.value}
+	j := i + 1
+	for j+1 < len(l.toks) && l.is(j, tokOp, ".") && l.toks[j+1].kind == tokName {
+		names = append(names, l.toks[j+1].value)
+		j += 2
+	}
+
+	if l.local(names[0]) || l.globals[names[0]] {
+		return j
+	}
+
+	g, known := l.std.globals[names[0]]
+	if !known && !l.std.namespaces[names[0]] {
+		l.problem(t, "unknown global %s", names[0])
+		return j
+	}
+	if g.Removed {
+		l.problem(t, "%s has been removed from Mercury", names[0])
+	}
+	l.substitution(t)
+
+	// only tables of globals have their fields checked, not instances
+	full := names[0]
+	resolved := 1
+	for _, name := range names[1:] {
+		if !l.std.namespaces[full] {
+			break
+		}
+		full += "." + name
+		var ok bool
+		if g, ok = l.std.globals[full]; !ok && !l.std.namespaces[full] {
+			l.problem(t, "unknown global %s", full)
+			return j
+		}
+		if g.Removed {
+			l.problem(t, "%s has been removed from Mercury", full)
+		}
+		known = ok
+		resolved++
+	}
+
+	if resolved == len(names) && known && g.Args != nil {
+		if count, ok := l.arguments(j); ok {
+			min, max := g.arity()
+			switch {
+			case count < min && min == max, max >= 0 && count > max && min == max:
+				l.problem(t, "%s takes %d argument(s), but is given %d", full, min, count)
+			case count < min:
+				l.problem(t, "%s takes at least %d argument(s), but is given %d", full, min, count)
+			case max >= 0 && count > max:
+				l.problem(t, "%s takes at most %d argument(s), but is given %d", full, max, count)
+			}
+		}
+	}
+	return j
+}
+
+// arguments counts the arguments of a call starting at i, if there is one
+// and the count is known
+func (l *linter) arguments(i int) (int, bool) {
+	if i >= len(l.toks) {
+		return 0, false
+	}
+	if t := l.toks[i]; t.kind == tokString || l.is(i, tokOp, "{") {
+		// f "string" or f {table}
+		return 1, true
+	}
+	if !l.is(i, tokOp, "(") {
+		return 0, false
+	}
+	if l.is(i+1, tokOp, ")") {
+		return 0, true
+	}
+
+	count, depth := 1, 0
+	for j := i + 1; j < len(l.toks); j++ {
+		t := l.toks[j]
+		if t.kind != tokOp {
+			continue
+		}
+		switch t.value {
+		case "(", "{", "[":
+			depth++
+		case ")", "}", "]":
+			if depth == 0 {
+				// calls and varargs at the end can be any number of values
+				if last := l.toks[j-1]; last.kind == tokOp && (last.value == ")" || last.value == "...") {
+					return 0, false
+				}
+				return count, true
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				count++
+			}
+		}
+	}
+	return 0, false
+}
+
+// substitution checks _SERVER and _CLIENT are used where they mean something
+func (l *linter) substitution(t token) {
+	var side string
+	switch t.value {
+	case "_SERVER":
+		side = "server"
+	case "_CLIENT":
+		side = "client"
+	default:
+		return
+	}
+
+	switch l.scriptType {
+	case side:
+	case "module":
+		l.problem(t, "%s is only substituted in server and client scripts, as ModuleScripts can run on either", t.value)
+	default:
+		l.problem(t, "%s is always false in a %s script", t.value, l.scriptType)
+	}
+}
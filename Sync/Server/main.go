@@ -1,24 +1,134 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"strings"
-
-	"path/filepath"
+	"runtime"
+	"strconv"
 
 	c "github.com/TwiN/go-color"
-	"github.com/gin-gonic/gin"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println(c.InRed("No target directory specified!"))
-		fmt.Println(c.InBlue("Run 'mercury-sync help' for more information."))
+// Version is set when building releases, with -ldflags "-X main.Version=..."
+var Version = "dev"
+
+// verbosity is how much is printed: 0 for only problems, 1 for progress as
+// well and 2 for every request too
+var verbosity = 1
+
+// info prints progress, unless running quietly
+func info(s string) {
+	if verbosity > 0 {
+		fmt.Println(s)
+	}
+}
+
+// flags every command has
+var (
+	configPath string
+	verbose    bool
+	quiet      bool
+)
+
+// command is a subcommand of the binary. setup adds the command's flags,
+// returning the function to run it with the arguments left over.
+type command struct {
+	name, usage, summary string
+	setup                func(flags *flag.FlagSet) func(args []string)
+}
+
+var commands []command
+
+func init() {
+	// set here, as help refers back to the list
+	commands = []command{
+		{"serve", "[directory]", "Sync a directory with Studio, the current one if not given", serveCommand},
+		{"build", "<directory>", "Build a directory into a model or place file", buildCommand},
+		{"init", "[directory]", "Create a new project, in the current directory if not given", initCommand},
+		{"trace", "<directory> [file]", "Rewrite a stack trace from Studio to point at the source files, reading it from stdin if no file is given", traceCommand},
+		{"version", "", "Print the version", versionCommand},
+		{"help", "[command]", "Show help for a command", helpCommand},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func newFlags(cmd command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.StringVar(&configPath, "config", ConfigFile, "config file to use")
+	flags.BoolVar(&verbose, "v", false, "print every request as well")
+	flags.BoolVar(&quiet, "q", false, "only print problems")
+	flags.Usage = func() {
+		fmt.Println(c.InBold("Usage: mercury-sync " + cmd.name + " " + cmd.usage))
+		fmt.Println(cmd.summary + ".")
+		fmt.Println()
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses flags, allowing them both before and after the
+// positional arguments, which are returned
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	switch {
+	case quiet:
+		verbosity = 0
+	case verbose:
+		verbosity = 2
+	}
+	return positional
+}
+
+// loadConfig exits if the config file can't be read
+func loadConfig() {
+	if err := LoadConfig(configPath); err != nil {
+		fmt.Println(c.InRed("Error while reading config file:"), err)
+		os.Exit(1)
+	}
+}
+
+// useProfile exits if a profile isn't in the config
+func useProfile(name string) *Profile {
+	p, err := findProfile(name)
+	if err != nil {
+		fmt.Println(c.InRed("Error while reading config file:"), err)
 		os.Exit(1)
 	}
-	target := os.Args[1]
+	if p.Name != "" {
+		info(c.InBlue("Using profile ") + c.InBold(p.Name))
+	}
+	return p
+}
+
+// usageError exits after showing how a command should be used
+func usageError(cmd string) {
+	fmt.Println(c.InRed("Wrong number of arguments!"))
+	fmt.Println(c.InBlue("Run 'mercury-sync help " + cmd + "' for more information."))
+	os.Exit(1)
+}
 
+// checkTarget exits if the target isn't a directory
+func checkTarget(target string) {
 	fi, err := os.Stat(target)
 	if err != nil {
 		fmt.Println(c.InRed("Target directory ") + c.InUnderline(c.InPurple(target)) + c.InRed(" does not exist!"))
@@ -28,145 +138,165 @@ func main() {
 		fmt.Println(c.InUnderline(c.InPurple(target)) + c.InRed(" is a file, please choose a directory to sync with!"))
 		os.Exit(1)
 	}
+}
 
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.New()
-	r.Use(gin.Recovery())
-	r.SetTrustedProxies([]string{"127.0.0.1"})
-
-	r.GET("/", func(cx *gin.Context) {
-		cx.String(200, "Mercury Sync")
-	})
-	r.GET("/sync", func(cx *gin.Context) {
-		fmt.Println(c.InYellow("Syncing..."))
+func serveCommand(flags *flag.FlagSet) func([]string) {
+	port := flags.Int("port", 2013, "port to listen on, which the plugin expects to be 2013")
+	bind := flags.String("bind", "", "address to listen on (default 127.0.0.1, or 0.0.0.0 with remote = true in the config)")
+	profile := flags.String("profile", "", "profile from the config to sync with, unless Studio asks for another (default the config's profile)")
 
-		// Create struct for JSON response
-		type File struct {
-			Path    []string `json:"path"`
-			Content string   `json:"content"`
-			Type    string   `json:"type"`
+	return func(args []string) {
+		if len(args) > 1 {
+			usageError("serve")
 		}
-		var Response struct {
-			Files []File `json:"files"`
-			// Message string `json:"message"`
+		target := "."
+		if len(args) == 1 {
+			target = args[0]
 		}
+		checkTarget(target)
+		loadConfig()
 
-		usedScripts := make(map[string]bool)
-		// Read files recursively and send them to the client
-		filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				fmt.Println(c.InRed("Error while reading file:"), err.Error())
-				return nil
+		host := *bind
+		if host == "" {
+			host = "127.0.0.1"
+			if config.Remote {
+				host = "0.0.0.0"
 			}
+		}
+		serve(target, net.JoinHostPort(host, strconv.Itoa(*port)), useProfile(*profile))
+	}
+}
 
-			if info.IsDir() {
-				return nil
-			}
+func buildCommand(flags *flag.FlagSet) func([]string) {
+	output := flags.String("o", "build.rbxmx", "output file, either .rbxmx for a model or .rbxlx for a place")
+	profile := flags.String("profile", "", "profile from the config to build with (default the config's profile)")
 
-			var filetype string
-			var scripttype string
-
-			switch strings.ToLower(filepath.Ext(path)) {
-			case ".lua":
-				filetype = "lua"
-			case ".luau":
-				filetype = "luau"
-			case ".moon":
-				filetype = "moon"
-			case ".yue":
-				filetype = "yue"
-			default:
-				return nil
-			}
+	return func(args []string) {
+		if len(args) != 1 {
+			usageError("build")
+		}
+		target := args[0]
+		checkTarget(target)
+		loadConfig()
+		p := useProfile(*profile)
 
-			// Trim target directory and extension from path, and remove suffix if it's a server/client script
-			formatPath := strings.TrimPrefix(path, target+string(os.PathSeparator))
-			formatPath = strings.TrimSuffix(formatPath, "."+filetype)
+		info(c.InYellow("Building ") + c.InUnderline(c.InPurple(target)) + c.InYellow("..."))
+		if err := build(target, *output, p); err != nil {
+			fmt.Println(c.InRed("Build failed:"), err)
+			os.Exit(1)
+		}
+		info(c.InGreen("Built to ") + c.InUnderline(c.InPurple(*output)))
+	}
+}
 
-			if strings.Contains(formatPath, ".") {
-				scripttype = strings.Split(formatPath, ".")[1]
-				if scripttype == "server" || scripttype == "client" {
-					formatPath = strings.Split(formatPath, ".")[0]
-				}
-			}
-			if scripttype == "" {
-				// scripttype = "module"
-				fmt.Println(c.InRed("Unknown script type: ") + c.InUnderline(c.InPurple(formatPath)) + c.InRed("!"))
-				fmt.Println(c.InYellow("If you were trying to sync a ModuleScript, these are not supported by Mercury Sync. Please transpose them manually."))
-				return nil
-			}
-			formatPath = strings.ReplaceAll(formatPath, string(os.PathSeparator), "$dot$")
+func initCommand(flags *flag.FlagSet) func([]string) {
+	project := flags.Bool("project", false, "create a "+ProjectFile+" as well")
+	force := flags.Bool("force", false, "overwrite files that already exist")
 
-			filename := strings.Split(formatPath, "$dot$")[len(strings.Split(formatPath, "$dot$"))-1]
-			if filename == "init" {
-				formatPath = strings.TrimSuffix(formatPath, "$dot$init")
-			}
+	return func(args []string) {
+		if len(args) > 1 {
+			usageError("init")
+		}
+		target := "."
+		if len(args) == 1 {
+			target = args[0]
+		}
 
-			dottedPath := strings.ReplaceAll(formatPath, "$dot$", ".")
+		if err := scaffold(target, *project, *force); err != nil {
+			fmt.Println(c.InRed("Error while creating project:"), err)
+			os.Exit(1)
+		}
+		info(c.InGreen("Created a new project in ") + c.InUnderline(c.InPurple(target)))
+		info(c.InBlue("Place a copy of darklua in the tools folder, or run 'aftman install', then run 'mercury-sync serve' to start syncing."))
+	}
+}
 
-			if usedScripts[formatPath] {
-				fmt.Println(c.InRed("Duplicate filename: ") + c.InUnderline(c.InPurple(dottedPath)) + c.InRed("! Skipping..."))
-				return nil
-			}
-			usedScripts[formatPath] = true
-
-			var content string
-
-			switch filetype {
-			case "luau":
-				fmt.Println(c.InBlue("Compiling  ") + c.InUnderline(c.InPurple(dottedPath)) + c.InBlue("..."))
-				content, err = CompileLuau(path)
-
-				if content == "" {
-					fmt.Println(c.InYellow("After compilation, file ") + c.InUnderline(c.InPurple(dottedPath)) + c.InYellow(" was empty!"))
-					content = "-- Mercury Sync: Empty file"
-				}
-
-				if err != nil {
-					fmt.Println(c.InRed("Error while compiling Luau file:"), err)
-					if strings.Contains(err.Error(), "file does not exist") ||
-						strings.Contains(err.Error(), "no such file or directory") {
-
-						fmt.Println(c.InYellow("Please place a copy of darklua (name \"darklua\" or \"darklua.exe\") in the tools folder."))
-					}
-					return nil
-				}
-			default:
-				file, err := os.ReadFile(path)
-				if err != nil {
-					fmt.Println(c.InRed("Error while reading file:"), err)
-					return nil
-				}
-				content = string(file)
-
-				if content == "" {
-					fmt.Println(c.InYellow("File ") + c.InUnderline(c.InPurple(dottedPath)) + c.InYellow(" is empty!"))
-					content = "-- Mercury Sync: Empty file"
-				}
-			}
+func traceCommand(flags *flag.FlagSet) func([]string) {
+	profile := flags.String("profile", "", "profile from the config the scripts were built with (default the config's profile)")
 
-			fmt.Println(c.InGreen("Sending    ") + c.InUnderline(c.InPurple(dottedPath)) + c.InGreen("..."))
+	return func(args []string) {
+		if len(args) < 1 || len(args) > 2 {
+			usageError("trace")
+		}
+		target := args[0]
+		checkTarget(target)
+		loadConfig()
 
-			scriptFile := File{
-				Path:    strings.Split(formatPath, "$dot$"),
-				Content: strings.ReplaceAll(content, "\r\n", "\n"),
-				Type:    scripttype,
-			}
+		var trace []byte
+		var err error
+		if len(args) == 2 {
+			trace, err = os.ReadFile(args[1])
+		} else {
+			trace, err = io.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			fmt.Println(c.InRed("Error while reading stack trace:"), err)
+			os.Exit(1)
+		}
+
+		// the trace goes to stdout, so anything scanning prints, like
+		// compile errors, goes to stderr instead
+		verbosity = 0
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		snapshot := NewSnapshot(target, useProfile(*profile))
+		snapshot.Scan()
+		os.Stdout = stdout
+		fmt.Print(snapshot.Trace(string(trace)))
+	}
+}
 
-			if filename == "init" {
-				Response.Files = append([]File{scriptFile}, Response.Files...)
-			} else {
-				Response.Files = append(Response.Files, scriptFile)
+func versionCommand(flags *flag.FlagSet) func([]string) {
+	return func(args []string) {
+		fmt.Println("mercury-sync " + Version + " (" + runtime.Version() + ", " + runtime.GOOS + "/" + runtime.GOARCH + ")")
+	}
+}
+
+func helpCommand(flags *flag.FlagSet) func([]string) {
+	return func(args []string) {
+		if len(args) > 0 {
+			cmd, ok := findCommand(args[0])
+			if !ok {
+				fmt.Println(c.InRed("Unknown command ") + c.InUnderline(c.InPurple(args[0])))
+				os.Exit(1)
 			}
+			cmdFlags := newFlags(cmd)
+			cmd.setup(cmdFlags)
+			cmdFlags.Usage()
+			return
+		}
 
-			return nil
-		})
+		fmt.Println(c.InBold(c.InGreen("~ Mercury Sync ~")))
+		fmt.Println("Usage: mercury-sync <command> [arguments]")
+		fmt.Println()
+		for _, cmd := range commands {
+			fmt.Printf("  %-8s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Println()
+		fmt.Println("Run 'mercury-sync help <command>' for a command's flags.")
+	}
+}
 
-		// os.Remove("./temp.lua")
+func main() {
+	if len(os.Args) < 2 {
+		helpCommand(nil)(nil)
+		os.Exit(1)
+	}
 
-		cx.JSON(200, Response)
-	})
+	cmd, ok := findCommand(os.Args[1])
+	args := os.Args[2:]
+	if !ok {
+		// a directory on its own is synced, as it always has been
+		if fi, err := os.Stat(os.Args[1]); err != nil || !fi.IsDir() {
+			fmt.Println(c.InRed("Unknown command ") + c.InUnderline(c.InPurple(os.Args[1])))
+			fmt.Println(c.InBlue("Run 'mercury-sync help' for more information."))
+			os.Exit(1)
+		}
+		cmd, _ = findCommand("serve")
+		args = os.Args[1:]
+	}
 
-	fmt.Println(c.InBold(c.InGreen("~ Mercury Sync ~")))
-	r.Run("0.0.0.0:2013")
+	flags := newFlags(cmd)
+	run := cmd.setup(flags)
+	run(parseArgs(flags, args))
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// Meta is a .meta.json file, setting properties of the script next to it,
+// or of the folder it's in if it's named init.meta.json
+type Meta struct {
+	ClassName  string         `json:"className"` // only for folders
+	Properties map[string]any `json:"properties"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+func readMeta(path string) (Meta, error) {
+	var m Meta
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	return m, jsonError(path, file, json.Unmarshal(file, &m))
+}
+
+// cleared returns the keys that were set, or were already cleared, and
+// aren't set any more
+func cleared(before map[string]any, already []string, now map[string]any) []string {
+	var keys []string
+	for k := range before {
+		if _, ok := now[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range already {
+		_, set := before[k]
+		if _, ok := now[k]; !ok && !set {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProjectFile is the optional manifest at the root of the target directory
+const ProjectFile = "mercury.project.json"
+
+// Project maps folders on disk to locations in the DataModel, instead of
+// the directory structure having to mirror game exactly
+type Project struct {
+	Mappings   []Mapping
+	Containers []Container
+	Ignore     []string
+}
+
+// Mapping is a file or directory on disk synced to an instance path
+type Mapping struct {
+	Path []string
+	Dir  string // relative to the target, separated by slashes
+}
+
+// Container is an instance that isn't a script, created so scripts have
+// somewhere to go
+type Container struct {
+	Path       []string       `json:"path"`
+	ClassName  string         `json:"className"`
+	Properties map[string]any `json:"properties,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+
+	Cleared           []string `json:"cleared,omitempty"` // like File.Cleared
+	ClearedAttributes []string `json:"clearedAttributes,omitempty"`
+}
+
+type projectJSON struct {
+	Tree   json.RawMessage `json:"tree"`
+	Ignore []string        `json:"ignore"`
+}
+
+// LoadProject reads the project file in the target directory, returning nil
+// if there isn't one
+func LoadProject(target string) (*Project, error) {
+	path := filepath.Join(target, ProjectFile)
+	file, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var pj projectJSON
+	if err := json.Unmarshal(file, &pj); err != nil {
+		return nil, jsonError(ProjectFile, file, err)
+	}
+	if pj.Tree == nil {
+		return nil, fmt.Errorf("%s: no tree specified", ProjectFile)
+	}
+
+	p := &Project{Ignore: pj.Ignore}
+	if err := p.addNode(nil, pj.Tree); err != nil {
+		return nil, fmt.Errorf("%s: %w", ProjectFile, err)
+	}
+
+	// longest first, so the most specific mapping wins
+	sort.SliceStable(p.Mappings, func(i, j int) bool {
+		return len(p.Mappings[i].Dir) > len(p.Mappings[j].Dir)
+	})
+	return p, nil
+}
+
+// addNode reads a node of the tree, whose keys are either $properties or
+// the names of its children
+func (p *Project) addNode(instancePath []string, raw json.RawMessage) error {
+	var node map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &node); err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(instancePath, "."), err)
+	}
+
+	var className string
+	if v, ok := node["$className"]; ok {
+		if err := json.Unmarshal(v, &className); err != nil {
+			return fmt.Errorf("%s.$className: %w", strings.Join(instancePath, "."), err)
+		}
+	} else if len(instancePath) > 1 {
+		// top level instances are services, anything below that is a folder
+		className = "Folder"
+	}
+	if className != "" && len(instancePath) > 0 {
+		p.Containers = append(p.Containers, Container{Path: instancePath, ClassName: className})
+	}
+
+	if v, ok := node["$path"]; ok {
+		var dir string
+		if err := json.Unmarshal(v, &dir); err != nil {
+			return fmt.Errorf("%s.$path: %w", strings.Join(instancePath, "."), err)
+		}
+		if len(instancePath) == 0 {
+			return fmt.Errorf("the root of the tree can't have a $path")
+		}
+		dir = path.Clean(filepath.ToSlash(dir))
+		if dir == ".." || strings.HasPrefix(dir, "../") || path.IsAbs(dir) {
+			return fmt.Errorf("%s.$path: %s is outside the target directory", strings.Join(instancePath, "."), dir)
+		}
+		p.Mappings = append(p.Mappings, Mapping{Path: instancePath, Dir: dir})
+	}
+
+	// sorted, so containers are always in the same order
+	var names []string
+	for name := range node {
+		if !strings.HasPrefix(name, "$") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := append(append([]string{}, instancePath...), name)
+		if err := p.addNode(childPath, node[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve finds the mapping a file or directory is in, returning the
+// instance path of the mapping and the rest of the path inside it
+func (p *Project) Resolve(rel string) ([]string, []string, bool) {
+	for _, m := range p.Mappings {
+		if m.Dir == "." {
+			return m.Path, strings.Split(rel, "/"), true
+		}
+		if rel == m.Dir {
+			return m.Path, nil, true
+		}
+		if strings.HasPrefix(rel, m.Dir+"/") {
+			return m.Path, strings.Split(rel[len(m.Dir)+1:], "/"), true
+		}
+	}
+	return nil, nil, false
+}
+
+// Unresolve finds where on disk an instance path should be, relative to the
+// target directory
+func (p *Project) Unresolve(instancePath []string) (string, bool) {
+	var best *Mapping
+	for i, m := range p.Mappings {
+		if len(m.Path) <= len(instancePath) && strings.Join(m.Path, "$dot$") == strings.Join(instancePath[:len(m.Path)], "$dot$") {
+			if best == nil || len(m.Path) > len(best.Path) {
+				best = &p.Mappings[i]
+			}
+		}
+	}
+	if best == nil || len(best.Path) == len(instancePath) {
+		// a mapping to a single file can't be written to as a new script
+		return "", false
+	}
+	return path.Join(append([]string{best.Dir}, instancePath[len(best.Path):]...)...), true
+}
+
+// Ignored returns whether a file or directory matches any ignore globs
+func (p *Project) Ignored(rel string) bool {
+	for _, pattern := range p.Ignore {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated path against a glob, where ** matches
+// any number of directories. Patterns without a slash match the file name.
+func matchGlob(pattern, rel string) bool {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "/")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,213 @@
+package main
+
+// scope works out which names are locals while walking through a file's
+// tokens. It only tracks enough of Lua's syntax to tell locals from globals.
+type scope struct {
+	toks     []token
+	scopes   []map[string]bool // false for names that aren't in scope yet
+	brackets []string
+	skipDo   int // for loops open their scope before their do
+
+	// names from a local statement, which aren't in scope until it ends,
+	// and how deep the statement was
+	pending         []string
+	pendingScopes   int
+	pendingBrackets int
+}
+
+func newScope(toks []token) scope {
+	return scope{toks: toks, scopes: []map[string]bool{{}}}
+}
+
+func (s *scope) is(i int, kind int, value string) bool {
+	return i >= 0 && i < len(s.toks) && s.toks[i].kind == kind && s.toks[i].value == value
+}
+
+func (s *scope) declare(name string) {
+	s.scopes[len(s.scopes)-1][name] = true
+}
+
+func (s *scope) push() {
+	s.scopes = append(s.scopes, map[string]bool{})
+}
+
+func (s *scope) pop() {
+	if len(s.scopes) > 1 {
+		s.scopes = s.scopes[:len(s.scopes)-1]
+	}
+}
+
+func (s *scope) local(name string) bool {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if s.scopes[i][name] {
+			return true
+		}
+	}
+	return false
+}
+
+// walk goes through the tokens, calling reference with the index of each
+// name that isn't being declared, a field or a table key. reference returns
+// the index of the token after the reference.
+func (s *scope) walk(reference func(i int) int) {
+	for i := 0; i < len(s.toks); i++ {
+		s.endLocal(i)
+		t := s.toks[i]
+
+		switch t.kind {
+		case tokKeyword:
+			switch t.value {
+			case "local":
+				if s.is(i+1, tokKeyword, "function") {
+					// it can call itself, so it's in scope straight away
+					if i+2 < len(s.toks) {
+						s.declare(s.toks[i+2].value)
+					}
+					continue
+				}
+				var names []string
+				for i+1 < len(s.toks) && s.toks[i+1].kind == tokName {
+					names = append(names, s.toks[i+1].value)
+					i++
+					if !s.is(i+1, tokOp, ",") {
+						break
+					}
+					i++
+				}
+				s.pending, s.pendingScopes, s.pendingBrackets = names, len(s.scopes), len(s.brackets)
+			case "function":
+				i = s.function(i, reference)
+			case "for":
+				// the loop's variables aren't in scope until its do
+				s.push()
+				s.skipDo++
+				for i+1 < len(s.toks) && !s.is(i+1, tokKeyword, "in") && !s.is(i+1, tokOp, "=") {
+					i++
+					if s.toks[i].kind == tokName {
+						s.scopes[len(s.scopes)-1][s.toks[i].value] = false
+					}
+				}
+			case "do":
+				if s.skipDo > 0 {
+					s.skipDo--
+					for name := range s.scopes[len(s.scopes)-1] {
+						s.declare(name)
+					}
+				} else {
+					s.push()
+				}
+			case "then", "repeat":
+				s.push()
+			case "elseif", "end":
+				s.pop()
+			case "else":
+				s.pop()
+				s.push()
+			case "until":
+				// the condition can see the loop's locals
+				if len(s.scopes) > 1 {
+					for name := range s.scopes[len(s.scopes)-1] {
+						s.scopes[len(s.scopes)-2][name] = true
+					}
+				}
+				s.pop()
+			}
+
+		case tokOp:
+			switch t.value {
+			case "(", "{", "[":
+				s.brackets = append(s.brackets, t.value)
+			case ")", "}", "]":
+				if len(s.brackets) > 0 {
+					s.brackets = s.brackets[:len(s.brackets)-1]
+				}
+			}
+
+		case tokName:
+			if i > 0 && (s.is(i-1, tokOp, ".") || s.is(i-1, tokOp, ":")) {
+				continue
+			}
+			// keys in table constructors
+			if s.inTable() && s.is(i+1, tokOp, "=") {
+				continue
+			}
+			i = reference(i) - 1
+		}
+	}
+}
+
+// inTable returns whether the innermost bracket is a table constructor
+func (s *scope) inTable() bool {
+	return len(s.brackets) > 0 && s.brackets[len(s.brackets)-1] == "{"
+}
+
+// function declares a function's parameters in a new scope, returning the
+// index of the closing parenthesis
+func (s *scope) function(i int, reference func(i int) int) int {
+	method := false
+	j := i + 1
+	for j < len(s.toks) && !s.is(j, tokOp, "(") {
+		if s.is(j, tokOp, ":") {
+			method = true
+		}
+		j++
+	}
+
+	// the function's name is a reference like any other
+	if j > i+1 && s.toks[i+1].kind == tokName {
+		reference(i + 1)
+	}
+
+	s.push()
+	if method {
+		s.declare("self")
+	}
+	for j++; j < len(s.toks) && !s.is(j, tokOp, ")"); j++ {
+		if s.toks[j].kind == tokName {
+			s.declare(s.toks[j].value)
+		}
+	}
+	return j
+}
+
+// endLocal declares the names from a local statement once another
+// statement starts at the same depth, so local x = x still means the global
+func (s *scope) endLocal(i int) {
+	if s.pending == nil || len(s.scopes) != s.pendingScopes || len(s.brackets) != s.pendingBrackets {
+		return
+	}
+	t := s.toks[i]
+	start := false
+	switch t.kind {
+	case tokOp:
+		start = t.value == ";"
+	case tokKeyword:
+		switch t.value {
+		case "local", "if", "for", "while", "do", "return", "repeat", "break", "goto", "end", "until", "else", "elseif":
+			start = true
+		case "function":
+			start = i > 0 && endsExpression(s.toks[i-1])
+		}
+	case tokName:
+		// two expressions can only be next to each other in separate statements
+		start = i > 0 && endsExpression(s.toks[i-1])
+	}
+	if !start {
+		return
+	}
+	for _, name := range s.pending {
+		s.declare(name)
+	}
+	s.pending = nil
+}
+
+// endsExpression returns whether an expression can end with a token
+func endsExpression(t token) bool {
+	switch t.kind {
+	case tokName, tokNumber, tokString:
+		return true
+	case tokOp:
+		return t.value == ")" || t.value == "]" || t.value == "}" || t.value == "..."
+	}
+	return t.value == "end" || t.value == "true" || t.value == "false" || t.value == "nil"
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	c "github.com/TwiN/go-color"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// how long /sync/changes waits for a change before returning nothing
+const pollTimeout = 30 * time.Second
+
+// how often /events sends something, so dead connections are noticed
+const keepAlive = 15 * time.Second
+
+// sessions is how many Studio sessions are listening for changes
+var sessions atomic.Int32
+
+// serve syncs the target directory with Studio, listening on addr. Requests
+// use the given profile unless they ask for another.
+func serve(target, addr string, profile *Profile) {
+	// each profile builds scripts differently, so has its own snapshot,
+	// made the first time it's asked for
+	var snapshotsMu sync.Mutex
+	snapshots := make(map[string]*Snapshot)
+	snapshotFor := func(p *Profile) *Snapshot {
+		snapshotsMu.Lock()
+		defer snapshotsMu.Unlock()
+
+		if s, ok := snapshots[p.Name]; ok {
+			return s
+		}
+		s := NewSnapshot(target, p)
+		s.Scan()
+		if err := s.Watch(); err != nil {
+			fmt.Println(c.InRed("Failed to watch target directory:"), err)
+			fmt.Println(c.InYellow("Changes will only be picked up when syncing."))
+		}
+		snapshots[p.Name] = s
+		return s
+	}
+	snapshotFor(profile)
+
+	// requestSnapshot is the snapshot for the profile a request asks for
+	requestSnapshot := func(cx *gin.Context) *Snapshot {
+		if cx.Query("profile") == "" {
+			return snapshotFor(profile)
+		}
+		p, err := findProfile(cx.Query("profile"))
+		if err != nil {
+			cx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+			return nil
+		}
+		return snapshotFor(p)
+	}
+
+	token := config.Token
+	if token == "" {
+		token = newToken()
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+	if verbosity > 1 {
+		r.Use(gin.Logger())
+	}
+	r.Use(requireToken(token))
+	r.SetTrustedProxies([]string{"127.0.0.1"})
+
+	r.GET("/", func(cx *gin.Context) {
+		cx.String(200, "Mercury Sync")
+	})
+	r.GET("/sync", func(cx *gin.Context) {
+		snapshot := requestSnapshot(cx)
+		if snapshot == nil {
+			return
+		}
+		info(c.InYellow("Syncing..."))
+
+		// Pick up anything the watcher hasn't got to yet
+		snapshot.Scan()
+
+		// Create struct for JSON response
+		var Response struct {
+			Files      []File      `json:"files"`
+			Removed    []Removal   `json:"removed"`
+			Containers []Container `json:"containers"`
+			Revision   int         `json:"revision"`
+
+			Diagnostics []Diagnostic `json:"diagnostics"`
+		}
+		// removals are worked out from the client's own revision, as other
+		// Studio sessions may have synced since
+		since, _ := strconv.Atoi(cx.Query("since"))
+		Response.Files, Response.Removed, Response.Containers, Response.Revision = snapshot.Sync(since)
+		Response.Diagnostics = snapshot.Diagnostics()
+
+		for _, f := range Response.Files {
+			info(c.InGreen("Sending    ") + c.InUnderline(c.InPurple(strings.Join(f.Path, "."))) + c.InGreen("..."))
+		}
+
+		for _, r := range Response.Removed {
+			if r.RenamedTo != nil {
+				info(c.InBlue("Renaming   ") + c.InUnderline(c.InPurple(strings.Join(r.Path, "."))) + c.InBlue(" to ") + c.InUnderline(c.InPurple(strings.Join(r.RenamedTo, "."))) + c.InBlue("..."))
+			} else {
+				info(c.InYellow("Removing   ") + c.InUnderline(c.InPurple(strings.Join(r.Path, "."))) + c.InYellow("..."))
+			}
+		}
+
+		cx.JSON(200, Response)
+	})
+	r.POST("/write", func(cx *gin.Context) {
+		var req WriteRequest
+		if err := cx.ShouldBindJSON(&req); err != nil {
+			cx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		dottedPath := strings.Join(req.Path, ".")
+		snapshot := requestSnapshot(cx)
+		if snapshot == nil {
+			return
+		}
+
+		path, err := snapshot.Write(req)
+		switch {
+		case err == nil:
+			info(c.InGreen("Wrote      ") + c.InUnderline(c.InPurple(dottedPath)) + c.InGreen(" to ") + c.InUnderline(c.InPurple(path)))
+			cx.JSON(200, gin.H{"path": path})
+		case errors.Is(err, errConflict), errors.Is(err, errCompiled), errors.Is(err, errTypeDiff), errors.Is(err, errRewritten):
+			fmt.Println(c.InRed("Refusing to write ") + c.InUnderline(c.InPurple(dottedPath)) + c.InRed(": "+err.Error()))
+			cx.JSON(409, gin.H{"error": err.Error()})
+		case errors.Is(err, errBadPath), errors.Is(err, errBadType):
+			cx.JSON(400, gin.H{"error": err.Error()})
+		default:
+			fmt.Println(c.InRed("Error while writing file:"), err)
+			cx.JSON(500, gin.H{"error": err.Error()})
+		}
+	})
+	r.POST("/trace", func(cx *gin.Context) {
+		snapshot := requestSnapshot(cx)
+		if snapshot == nil {
+			return
+		}
+		trace, err := io.ReadAll(cx.Request.Body)
+		if err != nil {
+			cx.String(400, err.Error())
+			return
+		}
+		cx.String(200, snapshot.Trace(string(trace)))
+	})
+	r.GET("/sync/changes", func(cx *gin.Context) {
+		snapshot := requestSnapshot(cx)
+		if snapshot == nil {
+			return
+		}
+		since, _ := strconv.Atoi(cx.Query("since"))
+		timeout := pollTimeout
+		if t, err := strconv.Atoi(cx.Query("timeout")); err == nil && t >= 0 && time.Duration(t)*time.Second < pollTimeout {
+			timeout = time.Duration(t) * time.Second
+		}
+		deadline := time.After(timeout)
+
+		// Block until there's something newer than the client has
+		for {
+			changes, next := snapshot.Since(since)
+			if changes.Revision != since {
+				snapshot.Delivered(changes)
+				cx.JSON(200, changes)
+				return
+			}
+
+			select {
+			case <-next:
+			case <-deadline:
+				cx.JSON(200, changes)
+				return
+			case <-cx.Request.Context().Done():
+				return
+			}
+		}
+	})
+
+	r.GET("/events", func(cx *gin.Context) {
+		snapshot := requestSnapshot(cx)
+		if snapshot == nil {
+			return
+		}
+		// each connection has its own cursor, which a reconnecting client
+		// gives back as the ID of the last event it got
+		since, _ := strconv.Atoi(cx.GetHeader("Last-Event-ID"))
+		if q := cx.Query("since"); q != "" {
+			since, _ = strconv.Atoi(q)
+		}
+
+		info(c.InGreen("Studio session connected, ") + c.InBold(strconv.Itoa(int(sessions.Add(1)))) + c.InGreen(" listening"))
+		defer func() {
+			info(c.InYellow("Studio session disconnected, ") + c.InBold(strconv.Itoa(int(sessions.Add(-1)))) + c.InYellow(" listening"))
+		}()
+
+		cx.Header("Cache-Control", "no-cache")
+		for {
+			changes, next := snapshot.Since(since)
+			if changes.Revision != since {
+				snapshot.Delivered(changes)
+				cx.Render(-1, sse.Event{
+					Id:    strconv.Itoa(changes.Revision),
+					Event: "changes",
+					Data:  changes,
+				})
+				cx.Writer.Flush()
+				since = changes.Revision
+			}
+
+			select {
+			case <-next:
+			case <-time.After(keepAlive):
+				cx.Render(-1, sse.Event{Event: "ping", Data: since})
+				cx.Writer.Flush()
+			case <-cx.Request.Context().Done():
+				return
+			}
+		}
+	})
+
+	if host, _, _ := net.SplitHostPort(addr); host != "localhost" && !net.ParseIP(host).IsLoopback() {
+		fmt.Println(c.InYellow("Remote access is on, anyone on the network with the pairing token can sync."))
+	}
+
+	fmt.Println(c.InBold(c.InGreen("~ Mercury Sync ~")))
+	fmt.Println(c.InBlue("Pairing token: ") + c.InBold(token))
+	if err := r.Run(addr); err != nil {
+		fmt.Println(c.InRed("Failed to start server:"), err)
+		os.Exit(1)
+	}
+}
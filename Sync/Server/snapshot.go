@@ -0,0 +1,653 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	c "github.com/TwiN/go-color"
+)
+
+type entry struct {
+	Source
+	file     File
+	hash     string
+	modTime  time.Time
+	size     int64
+	order    int // position in the walk, to keep output deterministic
+	created  int // revision the file was added in
+	modified int // revision the file was last changed in
+
+	diagnostics []Diagnostic // from the last time the file was compiled
+	compiled    string       // the content before any requires were bundled
+}
+
+type tombstone struct {
+	sentFile
+	revision int
+}
+
+// Removal is a script that no longer exists on disk. If another script with
+// the same content appeared at the same time, it was probably renamed.
+type Removal struct {
+	Path      []string `json:"path"`
+	Type      string   `json:"type"`
+	RenamedTo []string `json:"renamedTo,omitempty"`
+}
+
+type sentFile struct {
+	path []string
+	hash string
+	typ  string
+}
+
+// Snapshot is a versioned copy of everything in the target directory, so
+// syncs only have to compile the files that changed since the last scan
+type Snapshot struct {
+	target  string
+	profile *Profile // what scripts are built with
+
+	scanning    sync.Mutex // only one scan at a time
+	mu          sync.Mutex
+	revision    int
+	entries     map[string]*entry
+	removed     map[string]tombstone
+	changed     chan struct{} // closed and replaced whenever the revision changes
+	dirs        []string
+	project     *Project
+	folders     []Container // containers from the project, and directories without an init script
+	diagnostics []Diagnostic
+	sent        map[string]sentFile // what the last /sync sent, to work out what was removed
+}
+
+// Changes is everything that happened after a given revision
+type Changes struct {
+	Revision   int         `json:"revision"`
+	Containers []Container `json:"containers"`
+	Added      []File      `json:"added"`
+	Modified   []File      `json:"modified"`
+	Removed    []Removal   `json:"removed"`
+
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+func NewSnapshot(target string, profile *Profile) *Snapshot {
+	return &Snapshot{
+		target:  target,
+		profile: profile,
+		entries: make(map[string]*entry),
+		removed: make(map[string]tombstone),
+		changed: make(chan struct{}),
+		sent:    make(map[string]sentFile),
+	}
+}
+
+// Scan walks the target directory and recompiles any files that changed
+// since the last scan, bumping the revision if anything is different
+func (s *Snapshot) Scan() {
+	s.scanning.Lock()
+	defer s.scanning.Unlock()
+
+	s.mu.Lock()
+	old := s.entries
+	oldFolders := make(map[string]Container)
+	for _, f := range s.folders {
+		oldFolders[strings.Join(f.Path, "$dot$")] = f
+	}
+	project := s.project
+	s.mu.Unlock()
+
+	var diagnostics []Diagnostic
+	if p, err := LoadProject(s.target); err != nil {
+		fmt.Println(c.InRed("Error while reading project file:"), err)
+		fmt.Println(c.InYellow("Using the last working project file instead."))
+		diagnostics = append(diagnostics, diagnose(ProjectFile, err))
+	} else {
+		project = p
+	}
+
+	// a new std means every script is linted again
+	var std *Std
+	if config.Lint {
+		var err error
+		if std, err = loadStd(s.target); err != nil {
+			fmt.Println(c.InRed("Error while reading "+StdFile+":"), err)
+			fmt.Println(c.InYellow("Linting against the built in Mercury std instead."))
+			diagnostics = append(diagnostics, diagnose(StdFile, err))
+			std, _ = loadEmbeddedStd()
+		}
+	}
+
+	// and a new darklua config means Luau files are compiled again
+	var darklua string
+	if useDarklua() {
+		darklua = darkluaConfigHash(s.profile)
+	}
+
+	found := make(map[string]*entry)
+	seen := make(map[string]bool)
+	var jobs []job
+	var dirs []string
+	var folders []Container
+	var changed []*entry
+	metas := make(map[string]Meta)
+	folderMetas := make(map[string]Meta) // from init.meta.json
+	ignore := NewIgnore()
+	order := 0
+
+	filepath.Walk(s.target, func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(s.target, path)
+		rel = filepath.ToSlash(rel)
+
+		if err != nil {
+			fmt.Println(c.InRed("Error while reading file:"), err.Error())
+			diagnostics = append(diagnostics, diagnose(rel, err))
+			return nil
+		}
+
+		if info.IsDir() {
+			// ignored directories aren't walked at all
+			if rel != "." && ignore.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			if err := ignore.Load(path, rel); err != nil {
+				fmt.Println(c.InRed("Error while reading ignore file:"), err)
+				diagnostics = append(diagnostics, diagnose(rel, err))
+			}
+
+			if rel != "." && project != nil {
+				if project.Ignored(rel) {
+					return filepath.SkipDir
+				}
+				// Directories inside a mapping become folders, rather than models
+				if base, parts, ok := project.Resolve(rel); ok && len(parts) > 0 {
+					folders = append(folders, Container{Path: append(append([]string{}, base...), parts...), ClassName: "Folder"})
+				}
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		if ignore.Match(rel, false) || project != nil && project.Ignored(rel) {
+			return nil
+		}
+		src, err := findSource(path, rel, project)
+		if err == errUnknownType {
+			diagnostics = append(diagnostics, Diagnostic{Severity: "info", File: rel, Message: err.Error()})
+			return nil
+		} else if err == errSkip {
+			return nil
+		} else if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{Severity: "warning", File: rel, Message: err.Error()})
+			return nil
+		}
+
+		src.Profile = s.profile
+		src.Std = std
+		if src.FileType == "luau" {
+			src.Darklua = darklua
+		}
+
+		if src.FileType == "meta" {
+			m, err := readMeta(path)
+			if err != nil {
+				fmt.Println(c.InRed("Error while reading meta file:"), err)
+				diagnostics = append(diagnostics, diagnose(rel, err))
+				return nil
+			}
+			metas[src.Key] = m
+			if src.Init {
+				folderMetas[src.Key] = m
+			}
+			return nil
+		}
+
+		if seen[src.Key] {
+			fmt.Println(c.InRed("Duplicate filename: ") + c.InUnderline(c.InPurple(src.Dotted())) + c.InRed("! Skipping..."))
+			diagnostics = append(diagnostics, Diagnostic{Severity: "warning", File: rel, Message: "another file is already synced to " + src.Dotted() + ", skipping"})
+			return nil
+		}
+		seen[src.Key] = true
+		order++
+
+		e, ok := old[src.Key]
+		if ok && e.Source == src && e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+			// unchanged since last scan, but the entry is still in use, so
+			// copy it if it moved
+			if e.order != order {
+				n := *e
+				n.order = order
+				e = &n
+			}
+			found[src.Key] = e
+			return nil
+		}
+
+		jobs = append(jobs, job{src, info, order})
+		return nil
+	})
+
+	// Compile everything that changed at once, then go through the results in
+	// the order they were found so the output doesn't depend on timing
+	results := readAll(jobs)
+	for i, j := range jobs {
+		src, info, file, err := j.src, j.info, results[i].file, results[i].err
+		e, ok := old[src.Key]
+
+		if err != nil {
+			d := diagnose(src.Rel, err)
+			if ok {
+				// keep sending the last working version, but don't retry until the file changes again
+				e = &entry{Source: src, file: e.file, hash: e.hash, modTime: info.ModTime(), size: info.Size(), order: j.order, created: e.created, modified: e.modified, compiled: e.compiled}
+				e.diagnostics = []Diagnostic{d}
+				found[src.Key] = e
+			} else {
+				diagnostics = append(diagnostics, d)
+			}
+			continue
+		}
+
+		n := &entry{
+			Source:  src,
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			order:   j.order,
+			hash:    file.Hash(),
+			file:    file,
+
+			diagnostics: file.Diagnostics,
+			compiled:    file.Content,
+		}
+		if ok {
+			n.created = e.created
+			if e.hash == n.hash && e.file.Type == n.file.Type {
+				// touched, but nothing to send
+				n.modified = e.modified
+				found[src.Key] = n
+				continue
+			}
+		}
+		found[src.Key] = n
+		changed = append(changed, n)
+	}
+
+	// Meta files can change without the file they apply to changing
+	isChanged := make(map[*entry]bool)
+	for _, e := range changed {
+		isChanged[e] = true
+	}
+	for key, e := range found {
+		m := metas[key]
+		// anything the meta file stopped setting has to be put back in Studio,
+		// going by what was sent before the file was compiled again
+		last := e.file
+		if o := old[key]; o != nil {
+			last = o.file
+		}
+		props, attrs := cleared(last.Properties, last.Cleared, m.Properties), cleared(last.Attributes, last.ClearedAttributes, m.Attributes)
+		if reflect.DeepEqual(e.file.Properties, m.Properties) && reflect.DeepEqual(e.file.Attributes, m.Attributes) &&
+			reflect.DeepEqual(e.file.Cleared, props) && reflect.DeepEqual(e.file.ClearedAttributes, attrs) {
+			continue
+		}
+		if !isChanged[e] {
+			// entries from the last scan are still in use, so copy them
+			n := *e
+			e = &n
+			found[key] = e
+			changed = append(changed, e)
+		}
+		e.file.Properties, e.file.Attributes = m.Properties, m.Attributes
+		e.file.Cleared, e.file.ClearedAttributes = props, attrs
+	}
+
+	// So can the modules a script requires
+	if config.Bundle {
+		byPath := make(map[string]*entry)
+		for _, e := range found {
+			byPath[e.Path] = e
+		}
+		for key, e := range found {
+			if e.file.Instance != nil {
+				continue
+			}
+			content, err := bundle(e, byPath)
+			if err != nil {
+				fmt.Println(c.InRed("Error while bundling requires:"), err)
+				diagnostics = append(diagnostics, Diagnostic{Severity: "error", File: e.Rel, Message: err.Error()})
+				continue
+			}
+			if content == e.file.Content {
+				continue
+			}
+			if !isChanged[e] {
+				n := *e
+				e = &n
+				found[key] = e
+				isChanged[e] = true
+				changed = append(changed, e)
+			}
+			e.file.Content = content
+			e.hash = e.file.Hash()
+		}
+	}
+
+	var containers []Container
+	if project != nil {
+		containers = append(containers, project.Containers...)
+	}
+	for _, f := range folders {
+		// init scripts replace the folder they're in
+		if e := found[strings.Join(f.Path, "$dot$")]; e == nil || !e.Init {
+			containers = append(containers, f)
+		}
+	}
+	containers = applyMetas(containers, folderMetas, found)
+	containers = keepCleared(containers, oldFolders, found)
+	for i, f := range containers {
+		if last, ok := oldFolders[strings.Join(f.Path, "$dot$")]; ok {
+			containers[i].Cleared = cleared(last.Properties, last.Cleared, f.Properties)
+			containers[i].ClearedAttributes = cleared(last.Attributes, last.ClearedAttributes, f.Attributes)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dirs = dirs
+	s.project = project
+	for _, e := range found {
+		diagnostics = append(diagnostics, e.diagnostics...)
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].File < diagnostics[j].File
+	})
+	s.diagnostics = diagnostics
+	foldersChanged := !reflect.DeepEqual(s.folders, containers)
+	s.folders = containers
+
+	var removed []string
+	for key := range s.entries {
+		if found[key] == nil {
+			removed = append(removed, key)
+		}
+	}
+
+	if len(changed) == 0 && len(removed) == 0 && !foldersChanged {
+		s.entries = found
+		return
+	}
+
+	s.revision++
+	for _, e := range changed {
+		if old[e.Key] == nil {
+			e.created = s.revision
+		}
+		e.modified = s.revision
+		delete(s.removed, e.Key)
+	}
+	for _, key := range removed {
+		e := s.entries[key]
+		s.removed[key] = tombstone{sentFile{e.file.Path, e.hash, e.file.Type}, s.revision}
+	}
+	s.entries = found
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// applyMetas sets the class and properties of folders from their
+// init.meta.json, adding any folders that weren't containers already
+func applyMetas(containers []Container, metas map[string]Meta, found map[string]*entry) []Container {
+	byKey := make(map[string]int)
+	for i, f := range containers {
+		byKey[strings.Join(f.Path, "$dot$")] = i
+	}
+
+	var keys []string
+	for key := range metas {
+		// metas for scripts have already been applied
+		if found[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	// shortest first, so parents are created before their children
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := strings.Count(keys[i], "$dot$"), strings.Count(keys[j], "$dot$"); a != b {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		m := metas[key]
+		i, ok := byKey[key]
+		if !ok {
+			// the plugin creates models for folders by default
+			containers = append(containers, Container{Path: strings.Split(key, "$dot$"), ClassName: "Model"})
+			i = len(containers) - 1
+		}
+		if m.ClassName != "" {
+			containers[i].ClassName = m.ClassName
+		}
+		containers[i].Properties, containers[i].Attributes = m.Properties, m.Attributes
+	}
+	return containers
+}
+
+// keepCleared adds back containers that are only gone because their
+// init.meta.json was removed, so what it set can be cleared in Studio
+func keepCleared(containers []Container, old map[string]Container, found map[string]*entry) []Container {
+	inUse := func(path []string) bool {
+		for _, e := range found {
+			if len(e.file.Path) > len(path) && slices.Equal(e.file.Path[:len(path)], path) {
+				return true
+			}
+		}
+		return false
+	}
+	current := make(map[string]bool)
+	for _, f := range containers {
+		current[strings.Join(f.Path, "$dot$")] = true
+	}
+
+	var kept []Container
+	for key, f := range old {
+		if current[key] || len(f.Properties)+len(f.Attributes)+len(f.Cleared)+len(f.ClearedAttributes) == 0 || !inUse(f.Path) {
+			continue
+		}
+		kept = append(kept, Container{Path: f.Path, ClassName: f.ClassName})
+	}
+	// shortest first, like applyMetas
+	sort.Slice(kept, func(i, j int) bool {
+		if a, b := len(kept[i].Path), len(kept[j].Path); a != b {
+			return a < b
+		}
+		return strings.Join(kept[i].Path, ".") < strings.Join(kept[j].Path, ".")
+	})
+	return append(containers, kept...)
+}
+
+// sorted returns entries in the order the plugin should create them
+func sorted(entries []*entry) []*entry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+
+		// init files turn their folder into a script, so they have to be created
+		// before anything inside them, and parent folders before child folders
+		if a.Init != b.Init {
+			return a.Init
+		}
+		if a.Init && len(a.file.Path) != len(b.file.Path) {
+			return len(a.file.Path) < len(b.file.Path)
+		}
+		return a.order < b.order
+	})
+	return entries
+}
+
+// Files returns every file and container in the snapshot
+func (s *Snapshot) Files() ([]File, []Container) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*entry
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+
+	var files []File
+	for _, e := range sorted(entries) {
+		files = append(files, e.file)
+	}
+	return files, s.folders
+}
+
+// Diagnostics returns the problems found in the last scan
+func (s *Snapshot) Diagnostics() []Diagnostic {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.diagnostics
+}
+
+// Since returns the changes after a revision, and a channel that is closed
+// when the next revision is available
+func (s *Snapshot) Since(revision int) (Changes, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if revision > s.revision {
+		// the client is from before a restart, so send everything again
+		revision = 0
+	}
+
+	ch := Changes{Revision: s.revision, Containers: s.folders, Diagnostics: s.diagnostics}
+
+	var added, modified []*entry
+	for _, e := range s.entries {
+		if e.created > revision {
+			added = append(added, e)
+		} else if e.modified > revision {
+			modified = append(modified, e)
+		}
+	}
+	for _, e := range sorted(added) {
+		ch.Added = append(ch.Added, e.file)
+	}
+	for _, e := range sorted(modified) {
+		ch.Modified = append(ch.Modified, e.file)
+	}
+
+	var removed []sentFile
+	for _, t := range s.removed {
+		if t.revision > revision {
+			removed = append(removed, t.sentFile)
+		}
+	}
+	ch.Removed = renames(removed, added)
+
+	return ch, s.changed
+}
+
+// Delivered records changes as sent to the plugin, so scripts written back
+// from Studio are checked against what it was last sent
+func (s *Snapshot) Delivered(ch Changes) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, files := range [][]File{ch.Added, ch.Modified} {
+		for _, f := range files {
+			s.sent[strings.Join(f.Path, "$dot$")] = sentFile{f.Path, f.Hash(), f.Type}
+		}
+	}
+	for _, r := range ch.Removed {
+		delete(s.sent, strings.Join(r.Path, "$dot$"))
+	}
+}
+
+// Sync returns every file in the snapshot, and everything removed after a
+// revision, which each client keeps track of like with Since
+func (s *Snapshot) Sync(revision int) ([]File, []Removal, []Container, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if revision > s.revision {
+		// the client is from before a restart
+		revision = 0
+	}
+
+	var entries, added []*entry
+	for _, e := range s.entries {
+		entries = append(entries, e)
+		if e.created > revision {
+			added = append(added, e)
+		}
+	}
+
+	var removed []sentFile
+	for _, t := range s.removed {
+		if t.revision > revision {
+			removed = append(removed, t.sentFile)
+		}
+	}
+
+	s.sent = make(map[string]sentFile)
+	var files []File
+	for _, e := range sorted(entries) {
+		files = append(files, e.file)
+		s.sent[e.Key] = sentFile{e.file.Path, e.hash, e.file.Type}
+	}
+
+	return files, renames(removed, added), s.folders, s.revision
+}
+
+// renames turns removed files into removals, matching them up with added
+// files that have the same content and type, as a script can't change class
+// by being moved
+func renames(removed []sentFile, added []*entry) []Removal {
+	// deepest first, so children go before their parents
+	sort.SliceStable(removed, func(i, j int) bool {
+		a, b := removed[i].path, removed[j].path
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return strings.Join(a, ".") < strings.Join(b, ".")
+	})
+
+	byHash := make(map[string][]*entry)
+	for _, e := range sorted(added) {
+		key := e.hash + "\x00" + e.file.Type
+		byHash[key] = append(byHash[key], e)
+	}
+
+	var removals []Removal
+	for _, f := range removed {
+		r := Removal{Path: f.path, Type: f.typ}
+		key := f.hash + "\x00" + f.typ
+		if candidates := byHash[key]; len(candidates) > 0 {
+			r.RenamedTo = candidates[0].file.Path
+			byHash[key] = candidates[1:]
+		}
+		removals = append(removals, r)
+	}
+	return removals
+}
+
+func hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Dirs returns every directory seen in the last scan
+func (s *Snapshot) Dirs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirs
+}
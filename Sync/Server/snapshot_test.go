@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemovals(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		remove  []string
+		add     map[string]string
+		removed []Removal
+	}{
+		{
+			name:    "removed scripts",
+			files:   map[string]string{"a.server.lua": "print(1)", "b.lua": "return 1"},
+			remove:  []string{"a.server.lua"},
+			removed: []Removal{{Path: []string{"a"}, Type: "server"}},
+		},
+		{
+			name:    "renamed scripts",
+			files:   map[string]string{"a.server.lua": "print(1)"},
+			remove:  []string{"a.server.lua"},
+			add:     map[string]string{"sub/c.server.lua": "print(1)"},
+			removed: []Removal{{Path: []string{"a"}, Type: "server", RenamedTo: []string{"sub", "c"}}},
+		},
+		{
+			name:    "scripts with other content aren't renames",
+			files:   map[string]string{"a.server.lua": "print(1)"},
+			remove:  []string{"a.server.lua"},
+			add:     map[string]string{"c.server.lua": "print(2)"},
+			removed: []Removal{{Path: []string{"a"}, Type: "server"}},
+		},
+		{
+			name:    "scripts of another type aren't renames",
+			files:   map[string]string{"a.server.lua": "print(1)"},
+			remove:  []string{"a.server.lua"},
+			add:     map[string]string{"c.client.lua": "print(1)"},
+			removed: []Removal{{Path: []string{"a"}, Type: "server"}},
+		},
+		{
+			name:   "children are removed before their parents",
+			files:  map[string]string{"p/init.lua": "return 1", "p/c.lua": "return 2"},
+			remove: []string{"p/init.lua", "p/c.lua"},
+			removed: []Removal{
+				{Path: []string{"p", "c"}, Type: "module"},
+				{Path: []string{"p"}, Type: "module"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := t.TempDir()
+			writeFiles(t, target, tt.files)
+			s := NewSnapshot(target, &Profile{})
+			s.Scan()
+			_, _, _, first := s.Sync(0)
+
+			for _, rel := range tt.remove {
+				if err := os.Remove(filepath.Join(target, filepath.FromSlash(rel))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeFiles(t, target, tt.add)
+			s.Scan()
+
+			ch, _ := s.Since(first)
+			if !reflect.DeepEqual(ch.Removed, tt.removed) {
+				t.Errorf("Since removed %+v, want %+v", ch.Removed, tt.removed)
+			}
+
+			// each session gets the removals from its own revision, however
+			// many others synced in the meantime
+			_, _, _, latest := s.Sync(0)
+			if _, removed, _, _ := s.Sync(first); !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("Sync removed %+v, want %+v", removed, tt.removed)
+			}
+			if _, removed, _, _ := s.Sync(latest); len(removed) != 0 {
+				t.Errorf("Sync from the latest revision removed %+v", removed)
+			}
+		})
+	}
+}
+
+func TestScanSkipsHidden(t *testing.T) {
+	target := t.TempDir()
+	writeFiles(t, target, map[string]string{
+		".vscode/settings.json": "{}",
+		"src/.hidden.lua":       "return 1",
+		".darklua.json5":        "{}",
+		"a.lua":                 "return 1",
+	})
+	s := NewSnapshot(target, &Profile{})
+	s.Scan()
+
+	files, containers := s.Files()
+	if len(files) != 1 || !reflect.DeepEqual(files[0].Path, []string{"a"}) {
+		t.Errorf("synced %+v, want only a", files)
+	}
+	if len(containers) != 0 {
+		t.Errorf("made containers %+v", containers)
+	}
+	if d := s.Diagnostics(); len(d) != 0 {
+		t.Errorf("diagnostics %+v", d)
+	}
+}
+
+func TestRemovedMetas(t *testing.T) {
+	target := t.TempDir()
+	writeFiles(t, target, map[string]string{
+		"a.server.lua":       "print(1)",
+		"a.meta.json":        `{"properties": {"Disabled": true, "Name": "a"}, "attributes": {"x": 1}}`,
+		"dir/b.lua":          "return 1",
+		"dir/init.meta.json": `{"className": "Folder", "attributes": {"y": 2}}`,
+	})
+	s := NewSnapshot(target, &Profile{})
+	s.Scan()
+	_, _, _, first := s.Sync(0)
+
+	writeFiles(t, target, map[string]string{"a.meta.json": `{"properties": {"Name": "a"}}`})
+	if err := os.Remove(filepath.Join(target, "dir", "init.meta.json")); err != nil {
+		t.Fatal(err)
+	}
+	s.Scan()
+
+	ch, _ := s.Since(first)
+	if len(ch.Modified) != 1 {
+		t.Fatalf("modified %+v, want a", ch.Modified)
+	}
+	f := ch.Modified[0]
+	if !reflect.DeepEqual(f.Cleared, []string{"Disabled"}) || !reflect.DeepEqual(f.ClearedAttributes, []string{"x"}) {
+		t.Errorf("cleared %v and %v, want [Disabled] and [x]", f.Cleared, f.ClearedAttributes)
+	}
+	if len(ch.Containers) != 1 || !reflect.DeepEqual(ch.Containers[0].ClearedAttributes, []string{"y"}) {
+		t.Errorf("containers %+v, want dir with y cleared", ch.Containers)
+	}
+
+	// they stay cleared until the meta file sets them again
+	if err := os.Chtimes(filepath.Join(target, "a.server.lua"), time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s.Scan()
+	files, _ := s.Files()
+	if !reflect.DeepEqual(files[0].Cleared, []string{"Disabled"}) {
+		t.Errorf("cleared %v after a rescan, want [Disabled]", files[0].Cleared)
+	}
+	writeFiles(t, target, map[string]string{"a.meta.json": `{"properties": {"Disabled": false}}`})
+	s.Scan()
+	files, _ = s.Files()
+	if !reflect.DeepEqual(files[0].Cleared, []string{"Name"}) {
+		t.Errorf("cleared %v, want [Name]", files[0].Cleared)
+	}
+}
+
+// fakeDarklua writes the config it was given at the top of its output
+const fakeDarklua = `#!/bin/sh
+if [ "$1" = "--version" ]; then echo "darklua 0.0.0"; exit; fi
+shift
+config=$(cat .darklua.json5 2>/dev/null)
+if [ "$1" = "--config" ]; then config=$(cat "$2"); shift 2; fi
+echo "-- $config" > "$2"
+cat "$1" >> "$2"
+`
+
+func TestDarkluaConfigChanges(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake darklua is a shell script")
+	}
+	t.Chdir(t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	compiler := config.Compiler
+	config.Compiler = "darklua"
+	t.Cleanup(func() { config.Compiler = compiler })
+
+	writeFiles(t, ".", map[string]string{".darklua.json5": "{ rules: [] }", "target/a.server.luau": "print(1)"})
+	writeFiles(t, ".", map[string]string{darkluaPath: fakeDarklua})
+	if err := os.Chmod(darkluaPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSnapshot("target", &Profile{})
+	s.Scan()
+	writeFiles(t, ".", map[string]string{".darklua.json5": "{ rules: [\"remove_types\"] }"})
+	s.Scan()
+
+	files, _ := s.Files()
+	if len(files) != 1 || !strings.HasPrefix(files[0].Content, `-- { rules: ["remove_types"] }`) {
+		t.Errorf("compiled %+v, want it compiled with the new config", files)
+	}
+}
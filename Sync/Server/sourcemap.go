@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sameLines maps each line of the output to the same line of the source
+func sameLines(content string) []int {
+	lines := make([]int, strings.Count(content, "\n")+1)
+	for i := range lines {
+		lines[i] = i + 1
+	}
+	return lines
+}
+
+var yueLine = regexp.MustCompile(`-- (\d+)\s*$`)
+
+// yueLines reads the source lines yue -l adds to the end of each line. Lines
+// without one are part of the statement above.
+func yueLines(content string, _ *Profile) []int {
+	if !config.SourceMaps {
+		return nil
+	}
+
+	split := strings.Split(content, "\n")
+	lines := make([]int, len(split))
+	last := 1
+	for i, line := range split {
+		if m := yueLine.FindStringSubmatch(line); m != nil {
+			last, _ = strconv.Atoi(m[1])
+		}
+		lines[i] = last
+	}
+	return lines
+}
+
+var generator = regexp.MustCompile(`("?generator"?\s*:\s*)("[^"]*"|\{[^}]*\})`)
+
+// retainLines returns whether darklua is made to keep code on the same lines
+// as its source, which it is with source maps on unless its config sets
+// another generator
+func retainLines(p *Profile) bool {
+	if !config.SourceMaps {
+		return false
+	}
+	_, file, err := darkluaConfig(p)
+	if err != nil {
+		return false
+	}
+	m := generator.FindSubmatch(file)
+	return m == nil || string(m[2]) == `"retain_lines"`
+}
+
+// retainLinesConfig writes a copy of the darklua config that uses the
+// retain_lines generator, returning its path
+func retainLinesConfig(p *Profile) (string, error) {
+	name, file, err := darkluaConfig(p)
+	if err != nil {
+		return "", err
+	} else if name == "" {
+		file = []byte("{}")
+	}
+
+	// the config can only already set retain_lines, as others are left alone
+	out := string(file)
+	if i := configStart(file); i >= 0 && !generator.Match(file) {
+		out = string(file[:i+1]) + "\n\tgenerator: \"retain_lines\"," + string(file[i+1:])
+	}
+
+	temp, err := os.CreateTemp("", "mercury-sync-*.darklua.json5")
+	if err != nil {
+		return "", err
+	}
+	_, err = temp.WriteString(out)
+	temp.Close()
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// configStart finds the opening brace of a JSON5 config, skipping comments
+func configStart(file []byte) int {
+	for i := 0; i < len(file); i++ {
+		switch {
+		case file[i] == '{':
+			return i
+		case i+1 < len(file) && file[i] == '/' && file[i+1] == '/':
+			for i < len(file) && file[i] != '\n' {
+				i++
+			}
+		case i+1 < len(file) && file[i] == '/' && file[i+1] == '*':
+			end := strings.Index(string(file[i+2:]), "*/")
+			if end < 0 {
+				return -1
+			}
+			i += end + 3
+		}
+	}
+	return -1
+}
+
+// errors in Studio look like "Workspace.Script:12: message", and stack traces
+// like "Workspace.Script, line 12" or "Script 'Workspace.Script', Line 12"
+var traceLine = regexp.MustCompile(`((?:game\.)?[A-Za-z_]\w*(?:\.[A-Za-z_]\w*)*)(:|'?, [Ll]ine )(\d+)`)
+
+// Trace rewrites the script paths and line numbers in a Roblox stack trace to
+// the files and lines they were compiled from
+func (s *Snapshot) Trace(trace string) string {
+	s.mu.Lock()
+	scripts := make(map[string]*entry, len(s.entries))
+	for _, e := range s.entries {
+		if e.file.Instance == nil {
+			scripts[strings.Join(e.file.Path, ".")] = e
+		}
+	}
+	s.mu.Unlock()
+
+	return traceLine.ReplaceAllStringFunc(trace, func(match string) string {
+		m := traceLine.FindStringSubmatch(match)
+		e, ok := scripts[strings.TrimPrefix(m[1], "game.")]
+		if !ok || e.file.Lines == nil {
+			return match
+		}
+
+		line, _ := strconv.Atoi(m[3])
+		if line < 1 || line > len(e.file.Lines) {
+			return match
+		}
+		return e.Rel + m[2] + strconv.Itoa(e.file.Lines[line-1])
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// stdGlobal is a global from a selene standard library definition
+type stdGlobal struct {
+	Args    *[]stdArg `yaml:"args"` // only for functions
+	Struct  string    `yaml:"struct"`
+	Removed bool      `yaml:"removed"`
+}
+
+type stdArg struct {
+	Required any `yaml:"required"` // false, or a message for why it's required
+	Type     any `yaml:"type"`
+}
+
+// Std is the globals scripts can use, from mercury.yml and Lua 5.1, which
+// it's based on
+type Std struct {
+	globals    map[string]stdGlobal
+	namespaces map[string]bool // tables of globals, like CFrame or Enum.Material
+}
+
+// arity returns how many arguments a function takes, with max -1 if it
+// takes any number
+func (g stdGlobal) arity() (min, max int) {
+	for _, arg := range *g.Args {
+		if arg.Type == "..." {
+			return min, -1
+		}
+		if arg.Required != false {
+			min++
+		}
+		max++
+	}
+	return min, max
+}
+
+// Lua 5.1's globals, which mercury.yml doesn't list as it builds on them
+var lua51 = []string{
+	"_G", "_VERSION", "assert", "collectgarbage", "dofile", "error", "gcinfo",
+	"getfenv", "getmetatable", "ipairs", "load", "loadfile", "loadstring",
+	"module", "newproxy", "next", "pairs", "pcall", "print", "rawequal",
+	"rawget", "rawset", "require", "select", "setfenv", "setmetatable",
+	"tonumber", "tostring", "type", "unpack", "xpcall",
+
+	"coroutine.create", "coroutine.resume", "coroutine.running",
+	"coroutine.status", "coroutine.wrap", "coroutine.yield",
+
+	"debug.traceback",
+
+	"math.abs", "math.acos", "math.asin", "math.atan", "math.atan2",
+	"math.ceil", "math.cos", "math.cosh", "math.deg", "math.exp", "math.floor",
+	"math.fmod", "math.frexp", "math.huge", "math.ldexp", "math.log",
+	"math.log10", "math.max", "math.min", "math.modf", "math.pi", "math.pow",
+	"math.rad", "math.random", "math.randomseed", "math.sin", "math.sinh",
+	"math.sqrt", "math.tan", "math.tanh",
+
+	"os.clock", "os.date", "os.difftime", "os.time",
+
+	"string.byte", "string.char", "string.find", "string.format",
+	"string.gmatch", "string.gsub", "string.len", "string.lower",
+	"string.match", "string.rep", "string.reverse", "string.sub",
+	"string.upper",
+
+	"table.concat", "table.insert", "table.maxn", "table.remove", "table.sort",
+}
+
+// StdFile is the Mercury std a project is checked against, which selene
+// reads as well
+const StdFile = "mercury.yml"
+
+var (
+	embeddedStd     *Std
+	embeddedStdOnce sync.Once
+	embeddedStdErr  error
+)
+
+// loadEmbeddedStd reads the copy of the Mercury std embedded along with the
+// templates, for projects without their own
+func loadEmbeddedStd() (*Std, error) {
+	embeddedStdOnce.Do(func() {
+		file, err := templates.ReadFile("templates/" + StdFile)
+		if err != nil {
+			embeddedStdErr = err
+			return
+		}
+		embeddedStd, embeddedStdErr = parseStd(file)
+	})
+	return embeddedStd, embeddedStdErr
+}
+
+type projectStd struct {
+	std     *Std
+	modTime time.Time
+	size    int64
+}
+
+var (
+	projectStdsMu sync.Mutex
+	projectStds   = make(map[string]projectStd) // by path
+)
+
+// loadStd reads the Mercury std from the target's mercury.yml, falling back
+// to the embedded copy if it doesn't have one. It's only read again once it
+// changes, so the same std is returned until then.
+func loadStd(target string) (*Std, error) {
+	path := filepath.Join(target, StdFile)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return loadEmbeddedStd()
+	}
+
+	projectStdsMu.Lock()
+	defer projectStdsMu.Unlock()
+	if p, ok := projectStds[path]; ok && p.modTime.Equal(fi.ModTime()) && p.size == fi.Size() {
+		return p.std, nil
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	std, err := parseStd(file)
+	if err != nil {
+		return nil, err
+	}
+	projectStds[path] = projectStd{std, fi.ModTime(), fi.Size()}
+	return std, nil
+}
+
+// parseStd reads a selene standard library definition
+func parseStd(file []byte) (*Std, error) {
+	var def struct {
+		Globals map[string]stdGlobal `yaml:"globals"`
+	}
+	if err := yaml.Unmarshal(file, &def); err != nil {
+		return nil, err
+	}
+
+	std := &Std{globals: make(map[string]stdGlobal), namespaces: make(map[string]bool)}
+	for _, name := range lua51 {
+		std.globals[name] = stdGlobal{}
+	}
+	// mercury.yml wins, as it removes some of Lua's globals
+	for name, g := range def.Globals {
+		std.globals[name] = g
+	}
+	for name := range std.globals {
+		parts := strings.Split(name, ".")
+		for i := 1; i < len(parts); i++ {
+			std.namespaces[strings.Join(parts[:i], ".")] = true
+		}
+	}
+	return std, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// substitutions are filled in by the Mercury launcher, so scripts syncing
+// into Studio need them filled in from the config instead. _SERVER and
+// _CLIENT are left out, as they depend on the script type.
+var substitutions = []string{
+	"_USER_ID", "_CREATOR_ID", "_SERVER_PORT", "_SERVER_PRESENCE_URL",
+	"_SERVER_ADDRESS", "_PLACE_ID", "_IS_STUDIO_JOIN", "_MAP_LOCATION",
+	"_MAP_LOCATION_EXISTS", "_CHAR_APPEARANCE",
+}
+
+// substitutionLiterals checks substitution values from the config, returning
+// them as Lua literals
+func substitutionLiterals(values map[string]any) (map[string]string, error) {
+	literals := make(map[string]string, len(values))
+	for name, value := range values {
+		known := false
+		for _, s := range substitutions {
+			known = known || s == name
+		}
+		if !known {
+			return nil, fmt.Errorf("%s isn't a substitution, which are %s", name, strings.Join(substitutions, ", "))
+		}
+
+		switch v := value.(type) {
+		case string:
+			literals[name] = luaString(v)
+		case bool:
+			literals[name] = strconv.FormatBool(v)
+		case int64:
+			literals[name] = strconv.FormatInt(v, 10)
+		case float64:
+			literals[name] = strconv.FormatFloat(v, 'g', -1, 64)
+		default:
+			return nil, fmt.Errorf("substitution %s must be a string, number or boolean", name)
+		}
+	}
+	return literals, nil
+}
+
+// substitute replaces substitutions in compiled Lua with their values, as
+// either _NAME or _NAME(). Only globals are replaced, not locals or
+// parameters with the same name. Lines stay where they are, so source maps
+// and lint warnings still line up.
+func substitute(content string, literals map[string]string) (string, error) {
+	if len(literals) == 0 {
+		return content, nil
+	}
+	toks, err := lexLua(content)
+	if err != nil {
+		return content, err
+	}
+
+	var found []int
+	s := newScope(toks)
+	s.walk(func(i int) int {
+		if _, ok := literals[toks[i].value]; ok && !s.local(toks[i].value) && !assigned(&s, i) {
+			found = append(found, i)
+		}
+		return i + 1
+	})
+
+	// backwards, so offsets aren't moved by earlier replacements
+	for k := len(found) - 1; k >= 0; k-- {
+		i := found[k]
+		t := toks[i]
+		end := t.offset + len(t.value)
+		if i+2 < len(toks) && toks[i+1].value == "(" && toks[i+2].value == ")" {
+			end = toks[i+2].offset + 1
+		}
+		// keep any newlines between the name and its parentheses
+		newlines := strings.Repeat("\n", strings.Count(content[t.offset:end], "\n"))
+		content = content[:t.offset] + literals[t.value] + newlines + content[end:]
+	}
+	return content, nil
+}
+
+// assigned returns whether a global is being assigned to or defined as a
+// function, rather than read
+func assigned(s *scope, i int) bool {
+	if s.is(i-1, tokKeyword, "function") {
+		return true
+	}
+	if s.inTable() {
+		return false
+	}
+	// a, b, c = ...
+	for s.is(i+1, tokOp, ",") && i+2 < len(s.toks) && s.toks[i+2].kind == tokName {
+		i += 2
+	}
+	return s.is(i+1, tokOp, "=")
+}
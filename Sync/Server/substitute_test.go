@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestSubstitute(t *testing.T) {
+	literals := map[string]string{"_USER_ID": "5", "_SERVER_ADDRESS": `"localhost"`}
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"globals", "print(_USER_ID, _SERVER_ADDRESS)", `print(5, "localhost")`},
+		{"calls", "print(_USER_ID())", "print(5)"},
+		{"calls over lines", "print(_USER_ID(\n))\nerror()", "print(5\n)\nerror()"},
+		{"fields", "print(x._USER_ID, x:_USER_ID())", "print(x._USER_ID, x:_USER_ID())"},
+		{"table keys", "local t = {_USER_ID = _USER_ID}", "local t = {_USER_ID = 5}"},
+		{"assignments", "_USER_ID = 1\na, _USER_ID = 1, 2\n_USER_ID, a = 1, 2", "_USER_ID = 1\na, _USER_ID = 1, 2\n_USER_ID, a = 1, 2"},
+		{"function names", "function _USER_ID() end", "function _USER_ID() end"},
+		{"locals", "local _USER_ID = 1\nprint(_USER_ID)", "local _USER_ID = 1\nprint(_USER_ID)"},
+		{"locals from the global", "local _USER_ID = _USER_ID\nprint(_USER_ID)", "local _USER_ID = 5\nprint(_USER_ID)"},
+		{"locals in a block", "do local _USER_ID = 1 end print(_USER_ID)", "do local _USER_ID = 1 end print(5)"},
+		{"locals in a repeat", "repeat local _USER_ID = f() until _USER_ID", "repeat local _USER_ID = f() until _USER_ID"},
+		{
+			"parameters",
+			"local function f(_USER_ID) return _USER_ID end print(_USER_ID)",
+			"local function f(_USER_ID) return _USER_ID end print(5)",
+		},
+		{
+			"anonymous function parameters",
+			"local f = function(a, _USER_ID) return _USER_ID end",
+			"local f = function(a, _USER_ID) return _USER_ID end",
+		},
+		{
+			"local functions",
+			"local function _USER_ID() return _USER_ID end",
+			"local function _USER_ID() return _USER_ID end",
+		},
+		{
+			"loop variables",
+			"for _USER_ID = _USER_ID, 2 do print(_USER_ID) end print(_USER_ID)",
+			"for _USER_ID = 5, 2 do print(_USER_ID) end print(5)",
+		},
+		{
+			"generic loop variables",
+			"for _, _USER_ID in pairs(_USER_ID) do print(_USER_ID) end",
+			"for _, _USER_ID in pairs(5) do print(_USER_ID) end",
+		},
+		{
+			"locals aren't in scope inside their own function",
+			"local f = function() return f, _USER_ID end local _USER_ID = 1",
+			"local f = function() return f, 5 end local _USER_ID = 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := substitute(tt.source, literals)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
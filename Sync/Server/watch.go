@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	c "github.com/TwiN/go-color"
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long to wait for more events before rescanning, as editors often
+// write a file in several steps
+const debounce = 100 * time.Millisecond
+
+// Watch rescans the snapshot whenever anything in the target directory changes
+func (s *Snapshot) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	watchDirs := func() {
+		// fsnotify isn't recursive, so every directory needs its own watch
+		for _, dir := range s.Dirs() {
+			if err := watcher.Add(dir); err != nil {
+				fmt.Println(c.InRed("Error while watching directory:"), err)
+			}
+		}
+	}
+	watchDirs()
+
+	// darklua's config is in the working directory rather than the target, so
+	// only changes to the config itself are wanted from its directory
+	target, _ := filepath.Abs(s.target)
+	configs := make(map[string]bool)
+	for _, name := range darkluaFiles(s.profile) {
+		path, _ := filepath.Abs(name)
+		configs[path] = true
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			fmt.Println(c.InRed("Error while watching darklua config:"), err)
+		}
+	}
+
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				path, _ := filepath.Abs(event.Name)
+				if rel, err := filepath.Rel(target, path); !configs[path] && (err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+					continue
+				}
+				timer = time.After(debounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Println(c.InRed("Error while watching files:"), err)
+			case <-timer:
+				timer = nil
+				s.Scan()
+				watchDirs()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errConflict  = errors.New("the file has changed on disk since it was last synced")
+	errCompiled  = errors.New("the file is compiled, so edits have to be made to its source")
+	errBadPath   = errors.New("invalid script path")
+	errBadType   = errors.New("invalid script type")
+	errTypeDiff  = errors.New("the script type doesn't match the file on disk")
+	errRewritten = errors.New("the script is rewritten when it's synced, so edits have to be made to its source")
+)
+
+// WriteRequest is an edit made to a script in Studio
+type WriteRequest struct {
+	Path   []string `json:"path"`
+	Source string   `json:"source"`
+	Type   string   `json:"type"`
+	Force  bool     `json:"force"` // overwrite even if the file changed on disk
+}
+
+// Write saves a script edited in Studio back to disk, returning the path it
+// was written to
+func (s *Snapshot) Write(w WriteRequest) (string, error) {
+	if w.Type != "server" && w.Type != "client" && w.Type != "module" {
+		return "", errBadType
+	}
+	if len(w.Path) == 0 {
+		return "", errBadPath
+	}
+	for _, p := range w.Path {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, `/\`) || strings.Contains(p, "$dot$") {
+			return "", errBadPath
+		}
+	}
+	key := strings.Join(w.Path, "$dot$")
+
+	// Scanning is held for the whole write, so the watcher can't get in between
+	// the conflict check and the file being written
+	s.scanning.Lock()
+	s.mu.Lock()
+	e := s.entries[key]
+	sent, wasSent := s.sent[key]
+	project := s.project
+	s.mu.Unlock()
+
+	path, err := s.write(w, e, sent, wasSent, project)
+	s.scanning.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.sent[key] = sentFile{w.Path, hash(w.Source), w.Type}
+	s.mu.Unlock()
+
+	s.Scan()
+	return path, nil
+}
+
+func (s *Snapshot) write(w WriteRequest, e *entry, sent sentFile, wasSent bool, project *Project) (string, error) {
+	if e == nil {
+		// A new script, so create a file for it
+		path := filepath.Join(append([]string{s.target}, w.Path...)...)
+		if project != nil {
+			rel, ok := project.Unresolve(w.Path)
+			if !ok {
+				return "", errBadPath
+			}
+			path = filepath.Join(s.target, filepath.FromSlash(rel))
+		}
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			path = filepath.Join(path, "init")
+		}
+		if w.Type != "module" {
+			path += "." + w.Type
+		}
+
+		// the script may only be missing because its source failed to compile
+		for _, ext := range []string{".luau", ".moon", ".yue"} {
+			if _, err := os.Stat(path + ext); err == nil {
+				return "", errCompiled
+			}
+		}
+		path += ".lua"
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", err
+		}
+		// the file may be on disk without being synced, if it's ignored,
+		// unmapped or has the same name as another script
+		flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if w.Force {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(path, flag, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			return "", errConflict
+		} else if err != nil {
+			return "", err
+		}
+		if _, err = f.WriteString(w.Source); err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+
+	if e.FileType != "lua" {
+		return "", errCompiled
+	}
+	if e.ScriptType != w.Type {
+		return "", errTypeDiff
+	}
+	// bundled, or with substitutions or folding
+	if e.file.Rewritten || e.file.Content != e.compiled {
+		return "", errRewritten
+	}
+
+	if !w.Force {
+		current, err := readSource(e.Source)
+		if err != nil {
+			return "", err
+		}
+		// if it was never synced, Studio can only have what's in the snapshot
+		base := e.hash
+		if wasSent {
+			base = sent.hash
+		}
+		if current.Hash() != base {
+			return "", errConflict
+		}
+	}
+
+	return e.Path, os.WriteFile(e.Path, []byte(w.Source), 0o644)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates files by their path relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		edit    map[string]string // files changed on disk after the scan
+		req     WriteRequest
+		err     error
+		file    string // relative to the target
+		content string // of file afterwards
+	}{
+		{
+			name:    "new scripts are created",
+			req:     WriteRequest{Path: []string{"sub", "new"}, Source: "print(1)", Type: "server"},
+			file:    "sub/new.server.lua",
+			content: "print(1)",
+		},
+		{
+			name:    "new modules have no type in their name",
+			req:     WriteRequest{Path: []string{"m"}, Source: "return 1", Type: "module"},
+			file:    "m.lua",
+			content: "return 1",
+		},
+		{
+			name:    "directories get an init script",
+			files:   map[string]string{"dir/a.txt": "x"},
+			req:     WriteRequest{Path: []string{"dir"}, Source: "print(1)", Type: "client"},
+			file:    "dir/init.client.lua",
+			content: "print(1)",
+		},
+		{
+			name:    "synced scripts are overwritten",
+			files:   map[string]string{"a.server.lua": "print(1)"},
+			req:     WriteRequest{Path: []string{"a"}, Source: "print(2)", Type: "server"},
+			file:    "a.server.lua",
+			content: "print(2)",
+		},
+		{
+			name:    "scripts changed on disk are a conflict",
+			files:   map[string]string{"a.server.lua": "print(1)"},
+			edit:    map[string]string{"a.server.lua": "print(3)"},
+			req:     WriteRequest{Path: []string{"a"}, Source: "print(2)", Type: "server"},
+			err:     errConflict,
+			file:    "a.server.lua",
+			content: "print(3)",
+		},
+		{
+			name:    "unless forced",
+			files:   map[string]string{"a.server.lua": "print(1)"},
+			edit:    map[string]string{"a.server.lua": "print(3)"},
+			req:     WriteRequest{Path: []string{"a"}, Source: "print(2)", Type: "server", Force: true},
+			file:    "a.server.lua",
+			content: "print(2)",
+		},
+		{
+			name:    "ignored files aren't overwritten",
+			files:   map[string]string{IgnoreFile: "ign.server.lua", "ign.server.lua": "print(1)"},
+			req:     WriteRequest{Path: []string{"ign"}, Source: "CLOBBERED", Type: "server"},
+			err:     errConflict,
+			file:    "ign.server.lua",
+			content: "print(1)",
+		},
+		{
+			name:    "files created after the scan aren't overwritten",
+			edit:    map[string]string{"late.server.lua": "print(1)"},
+			req:     WriteRequest{Path: []string{"late"}, Source: "print(2)", Type: "server"},
+			err:     errConflict,
+			file:    "late.server.lua",
+			content: "print(1)",
+		},
+		{
+			name:  "scripts with another type",
+			files: map[string]string{"a.server.lua": "print(1)"},
+			req:   WriteRequest{Path: []string{"a"}, Source: "print(2)", Type: "client"},
+			err:   errTypeDiff,
+		},
+		{
+			name:  "scripts compiled from another language",
+			files: map[string]string{"a.server.moon": "print 1"},
+			req:   WriteRequest{Path: []string{"a"}, Source: "print(2)", Type: "server"},
+			err:   errCompiled,
+		},
+		{
+			name: "paths outside the target",
+			req:  WriteRequest{Path: []string{"..", "a"}, Source: "print(1)", Type: "server"},
+			err:  errBadPath,
+		},
+		{
+			name: "unknown types",
+			req:  WriteRequest{Path: []string{"a"}, Source: "print(1)", Type: "local"},
+			err:  errBadType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := t.TempDir()
+			writeFiles(t, target, tt.files)
+			s := NewSnapshot(target, &Profile{})
+			s.Scan()
+			writeFiles(t, target, tt.edit)
+
+			_, err := s.Write(tt.req)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.file == "" {
+				return
+			}
+			got, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(tt.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("%s = %q, want %q", tt.file, got, tt.content)
+			}
+		})
+	}
+}